@@ -2,25 +2,33 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"runtime"
 	"time"
 
-	"k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
-	"k8s.io/client-go/tools/record"
 
 	"github.com/coreos/etcd-backup-operator/pkg/operator"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
 	"github.com/coreos/etcd-backup-operator/version"
-	"github.com/coreos/etcd-operator/pkg/util/k8sutil"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 )
 
+var listenAddr string
+
+func init() {
+	flag.StringVar(&listenAddr, "listen-addr", fmt.Sprintf(":%d", constants.DefaultOperatorHTTPPort), "address to serve metrics and health checks on")
+	flag.Parse()
+}
+
 func main() {
 	namespace := os.Getenv("MY_POD_NAMESPACE")
 	if len(namespace) == 0 {
@@ -42,6 +50,9 @@ func main() {
 	}
 	kubecli := kubernetes.NewForConfigOrDie(kubecfg)
 
+	op := operator.New()
+	go serveHTTP(op)
+
 	id, err := os.Hostname()
 	if err != nil {
 		logrus.Fatalf("failed to get hostname: %v", err)
@@ -52,7 +63,7 @@ func main() {
 		kubecli,
 		resourcelock.ResourceLockConfig{
 			Identity:      id,
-			EventRecorder: createRecorder(kubecli, name, namespace),
+			EventRecorder: k8sutil.NewEventRecorder(kubecli, name, namespace),
 		})
 	if err != nil {
 		logrus.Fatalf("error creating lock: %v", err)
@@ -64,7 +75,9 @@ func main() {
 		RenewDeadline: 10 * time.Second,
 		RetryPeriod:   2 * time.Second,
 		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: run,
+			OnStartedLeading: func(stop <-chan struct{}) {
+				run(op, stop)
+			},
 			OnStoppedLeading: func() {
 				logrus.Fatalf("leader election lost")
 			},
@@ -73,17 +86,29 @@ func main() {
 	// unreachable
 }
 
-func createRecorder(kubecli kubernetes.Interface, name, namespace string) record.EventRecorder {
-	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(logrus.Infof)
-	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(kubecli.Core().RESTClient()).Events(namespace)})
-	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: name})
-}
-
-func run(stop <-chan struct{}) {
-	b := operator.New()
+func run(b *operator.Backup, stop <-chan struct{}) {
 	err := b.Start(context.TODO())
 	if err != nil {
 		logrus.Infof("operator stopped with %v", err)
 	}
 }
+
+// serveHTTP serves metrics and health checks.
+// /readyz fails while the leader syncs its caches. Standby instances are ready,
+// so that they don't hold up rollouts; the is_leader metric tells which instance leads.
+func serveHTTP(op *operator.Backup) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if !op.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	logrus.Infof("serving metrics and health checks on %s", listenAddr)
+	logrus.Fatal(http.ListenAndServe(listenAddr, mux))
+}
@@ -5,19 +5,22 @@ import (
 	"flag"
 	"os"
 
-	"github.com/Sirupsen/logrus"
 	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
 	"github.com/coreos/etcd-backup-operator/pkg/backup"
+	"github.com/coreos/etcd-backup-operator/pkg/client"
 	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	clusterName string
+	backupName  string
 	namespace   string
 )
 
 func init() {
 	flag.StringVar(&clusterName, "etcd-cluster", "", "")
+	flag.StringVar(&backupName, "backup-name", "", "name of the EtcdBackup this sidecar serves")
 	flag.Parse()
 
 	namespace = os.Getenv("MY_POD_NAMESPACE")
@@ -30,6 +33,9 @@ func main() {
 	if len(clusterName) == 0 {
 		panic("clusterName not set")
 	}
+	if len(backupName) == 0 {
+		panic("backupName not set")
+	}
 
 	var ebs api.EtcdBackupSpec
 	bss := os.Getenv("BACKUP_SPEC")
@@ -38,7 +44,7 @@ func main() {
 	}
 
 	kclient := k8sutil.MustNewKubeClient()
-	bk, err := backup.New(kclient, ebs, clusterName, namespace)
+	bk, err := backup.New(kclient, client.MustNewInCluster(), backupName, ebs, clusterName, namespace)
 	if err != nil {
 		logrus.Fatalf("failed to create backup sidecar: %v", err)
 	}
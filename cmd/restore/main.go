@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/backup"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	clusterName  string
+	key          string
+	revision     int64
+	destination  string
+	sidecarURL   string
+	skipIfExists string
+	output       string
+	namespace    string
+)
+
+func init() {
+	flag.StringVar(&clusterName, "etcd-cluster", "", "name of the etcd cluster the backup was taken from")
+	flag.StringVar(&key, "key", "", "name of the backup to restore")
+	flag.Int64Var(&revision, "revision", 0, "etcd revision of the backup to restore")
+	flag.StringVar(&destination, "destination", "", "name of the backup destination to restore from; defaults to the first one")
+	flag.StringVar(&sidecarURL, "sidecar-url", "", "URL of the HTTP API of the backup sidecar to download the backup through, instead of reading the storage directly")
+	flag.StringVar(&skipIfExists, "skip-if-exists", "", "path that, if it exists, means the backup was restored already and nothing is downloaded")
+	flag.StringVar(&output, "output", "", "file to download the backup to")
+	flag.Parse()
+
+	namespace = os.Getenv("MY_POD_NAMESPACE")
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+}
+
+func main() {
+	if len(clusterName) == 0 {
+		panic("clusterName not set")
+	}
+	if len(output) == 0 {
+		panic("output not set")
+	}
+
+	if len(skipIfExists) != 0 {
+		if _, err := os.Stat(skipIfExists); err == nil {
+			logrus.Infof("%s exists; skipped fetching backup", skipIfExists)
+			return
+		}
+	}
+	if len(sidecarURL) != 0 {
+		fetchFromSidecar()
+		return
+	}
+
+	var ebs api.EtcdBackupSpec
+	bss := os.Getenv(k8sutil.BackupSpec)
+	if err := json.Unmarshal([]byte(bss), &ebs); err != nil {
+		logrus.Fatalf("fail to parse backup policy (%s): %v", bss, err)
+	}
+
+	be, err := backup.NewDestinationBackend(ebs, destination, namespace, clusterName)
+	if err != nil {
+		logrus.Fatalf("failed to create backup backend: %v", err)
+	}
+	name, err := backup.SelectBackup(be, key, revision)
+	if err != nil {
+		logrus.Fatalf("failed to select backup: %v", err)
+	}
+	var kr backup.Keyring
+	if ebs.Encryption != nil {
+		kr, err = backup.LoadKeyring(constants.EncryptionKeyDir)
+		if err != nil {
+			logrus.Fatalf("failed to load encryption keys: %v", err)
+		}
+	}
+	switch _, err = backup.Verify(be, kr, name); err {
+	case nil:
+		logrus.Infof("verified backup %s", name)
+	case backup.ErrNoManifest:
+		logrus.Warningf("backup %s has no manifest; restoring it unverified", name)
+	default:
+		logrus.Fatalf("failed to verify backup: %v", err)
+	}
+	n, err := backup.Fetch(be, kr, name, output)
+	if err != nil {
+		logrus.Fatalf("failed to fetch backup: %v", err)
+	}
+	logrus.Infof("fetched backup %s (size: %d) successfully", name, n)
+	writeTerminationMessage(name)
+}
+
+// fetchFromSidecar downloads the backup through the HTTP API of the backup sidecar,
+// which verifies and decrypts it.
+func fetchFromSidecar() {
+	cli := &backup.SidecarClient{
+		URL:         sidecarURL,
+		Destination: destination,
+		Token:       os.Getenv(constants.EnvBackupAPIToken),
+	}
+	name, rev := key, revision
+	switch m, err := cli.Verify(key, revision); err {
+	case nil:
+		logrus.Infof("verified backup %s", m.Name)
+		// Download the verified backup, even if a newer one was taken since.
+		name, rev = m.Name, 0
+	case backup.ErrNoManifest:
+		logrus.Warningf("backup has no manifest; restoring it unverified")
+	default:
+		logrus.Fatalf("failed to verify backup: %v", err)
+	}
+	name, n, err := cli.Fetch(name, rev, output)
+	if err != nil {
+		logrus.Fatalf("failed to fetch backup: %v", err)
+	}
+	logrus.Infof("fetched backup %s (size: %d) successfully", name, n)
+	writeTerminationMessage(name)
+}
+
+// writeTerminationMessage records the name of the restored backup,
+// which the operator reads from the termination message.
+func writeTerminationMessage(name string) {
+	if err := ioutil.WriteFile(k8sutil.TerminationMessagePath, []byte(name), 0644); err != nil {
+		logrus.Warningf("failed to write termination message: %v", err)
+	}
+}
@@ -1,7 +1,27 @@
 package v1alpha1
 
+import "fmt"
+
+const (
+	BackupStorageTypeS3  = "s3"
+	BackupStorageTypePV  = "pv"
+	BackupStorageTypeGCS = "gcs"
+	BackupStorageTypeABS = "abs"
+
+	BackupCompressionNone = "none"
+	BackupCompressionGzip = "gzip"
+	BackupCompressionZstd = "zstd"
+
+	MemberSelectionMaxRevision    = "maxRevision"
+	MemberSelectionPreferFollower = "preferFollower"
+	MemberSelectionPreferLeader   = "preferLeader"
+)
+
 type StorageSource struct {
-	S3 *S3Source `json:"s3,omitempty"`
+	S3  *S3Source  `json:"s3,omitempty"`
+	PV  *PVSource  `json:"pv,omitempty"`
+	GCS *GCSSource `json:"gcs,omitempty"`
+	ABS *ABSSource `json:"abs,omitempty"`
 }
 
 type S3Source struct {
@@ -22,4 +42,178 @@ type S3Source struct {
 	//
 	// AWSSecret overwrites the default etcd operator wide AWS credential and config.
 	AWSSecret string `json:"awsSecret,omitempty"`
+
+	// Endpoint is the URL of an S3 compatible service, such as MinIO or Ceph RGW.
+	// If not set, the AWS S3 endpoint of the region is used.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Region is the region of the bucket.
+	// If not set, the region in the AWS config file is used.
+	Region string `json:"region,omitempty"`
+
+	// ForcePathStyle addresses the bucket in the URL path ("endpoint/bucket/key")
+	// instead of the host name ("bucket.endpoint/key"), as most S3 compatible services require.
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+
+	// CASecret is the name of the secret that stores the CA bundle to trust the endpoint with.
+	// The file name of the CA bundle MUST be 'ca.crt'.
+	CASecret string `json:"caSecret,omitempty"`
+
+	// MultipartUpload streams snapshots to S3 in a multipart upload,
+	// instead of copying them to a temporary file on the sidecar first.
+	MultipartUpload *S3MultipartUpload `json:"multipartUpload,omitempty"`
+}
+
+type S3MultipartUpload struct {
+	// PartSizeInMB is the size of each uploaded part. S3 requires at least 5MB;
+	// smaller sizes are rejected. The snapshot can be at most 10000 parts.
+	// Defaults to DefaultS3PartSizeInMB if 0.
+	PartSizeInMB int `json:"partSizeInMB,omitempty"`
+
+	// Concurrency is the number of parts uploaded in parallel.
+	// Each part in flight is buffered in memory.
+	// Defaults to DefaultS3UploadConcurrency.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+type GCSSource struct {
+	// Bucket is the name of the GCS bucket to store backups in.
+	Bucket string `json:"bucket"`
+
+	// Prefix is the prefix of the object names in the bucket.
+	// After that, it will have version and cluster specific paths.
+	Prefix string `json:"prefix,omitempty"`
+
+	// GCPSecret is the name of the secret object that stores the service account key.
+	// The file name of the key MUST be 'credentials.json'.
+	// If not set, the application default credentials of the sidecar are used.
+	GCPSecret string `json:"gcpSecret,omitempty"`
+
+	// Endpoint is the URL of a GCS compatible server to use instead of Google Cloud Storage,
+	// e.g. an emulator. Requests to it are only authenticated if GCPSecret is set.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+type ABSSource struct {
+	// Container is the name of the Azure Blob Storage container to store backups in.
+	Container string `json:"container"`
+
+	// Prefix is the prefix of the blob names in the container.
+	// After that, it will have version and cluster specific paths.
+	Prefix string `json:"prefix,omitempty"`
+
+	// ABSSecret is the name of the secret object that stores the storage account credentials.
+	// The file name of the storage account name MUST be 'storage-account'.
+	// Either the account key in 'storage-key' or a SAS token in 'storage-sas-token' MUST be set.
+	ABSSecret string `json:"absSecret"`
+
+	// BaseURL is the domain of the storage service, such as "core.chinacloudapi.cn" for Azure China.
+	// If not set, the public Azure cloud is used. Requests of the well-known account
+	// of the Azurite emulator, devstoreaccount1, go to the emulator on 127.0.0.1:10000.
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// APIVersion is the version of the storage service API used with the account key.
+	// If not set, the default version of the client library is used.
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// UseHTTP talks to the storage service over HTTP instead of HTTPS.
+	// It is only meant for emulators such as Azurite.
+	UseHTTP bool `json:"useHTTP,omitempty"`
+}
+
+type PVSource struct {
+	// VolumeSizeInMB specifies the required volume size for storing backups.
+	// Defaults to DefaultBackupVolumeSizeInMB.
+	VolumeSizeInMB int `json:"volumeSizeInMB,omitempty"`
+
+	// PVProvisioner is the provisioner of the PersistentVolume backups are stored on.
+	// It is one of "kubernetes.io/gce-pd", "kubernetes.io/aws-ebs" or "none".
+	// With "none", the default StorageClass of the Kubernetes cluster is used.
+	// Defaults to "none".
+	PVProvisioner string `json:"pvProvisioner,omitempty"`
+}
+
+// GetDestinations returns the destinations backups are written to.
+// Without Destinations, the storage of the spec itself is the only destination, named after its type.
+func (s *EtcdBackupSpec) GetDestinations() []BackupDestination {
+	if len(s.Destinations) != 0 {
+		return s.Destinations
+	}
+	return []BackupDestination{{
+		Name:          s.StorageType,
+		StorageType:   s.StorageType,
+		StorageSource: s.StorageSource,
+	}}
+}
+
+// GetDestination returns the destination of s with the given name.
+// The first destination is returned if name is empty.
+func (s *EtcdBackupSpec) GetDestination(name string) (BackupDestination, bool) {
+	ds := s.GetDestinations()
+	if len(name) == 0 {
+		return ds[0], true
+	}
+	for _, d := range ds {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return BackupDestination{}, false
+}
+
+// ValidateDestinations checks that the destinations of s have unique names and storage types.
+// Credentials are mounted at fixed paths per storage type, so the sidecar can't
+// talk to two destinations of the same type.
+func (s *EtcdBackupSpec) ValidateDestinations() error {
+	names := map[string]bool{}
+	types := map[string]bool{}
+	for _, d := range s.GetDestinations() {
+		if len(d.StorageType) == 0 {
+			if len(s.Destinations) == 0 {
+				return fmt.Errorf("storage type not set")
+			}
+			return fmt.Errorf("destination (%s) has no storage type", d.Name)
+		}
+		if len(d.Name) == 0 {
+			return fmt.Errorf("destination of storage type %s has no name", d.StorageType)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("duplicate destination name: %s", d.Name)
+		}
+		if types[d.StorageType] {
+			return fmt.Errorf("more than one destination of storage type: %s", d.StorageType)
+		}
+		names[d.Name] = true
+		types[d.StorageType] = true
+	}
+	return nil
+}
+
+// ValidateMemberSelection checks that the member selection policy of s is known.
+func (s *EtcdBackupSpec) ValidateMemberSelection() error {
+	switch s.MemberSelection {
+	case "", MemberSelectionMaxRevision, MemberSelectionPreferFollower, MemberSelectionPreferLeader:
+		return nil
+	default:
+		return fmt.Errorf("unknown member selection policy: %s", s.MemberSelection)
+	}
+}
+
+// ForDestination returns a copy of s that only writes to destination d.
+func (s *EtcdBackupSpec) ForDestination(d BackupDestination) EtcdBackupSpec {
+	sp := *s
+	sp.StorageType = d.StorageType
+	sp.StorageSource = d.StorageSource
+	sp.Destinations = nil
+	return sp
+}
+
+// HasStorageType tells whether any destination of s is of the given storage type.
+func (s *EtcdBackupSpec) HasStorageType(storageType string) bool {
+	for _, d := range s.GetDestinations() {
+		if d.StorageType == storageType {
+			return true
+		}
+	}
+	return false
 }
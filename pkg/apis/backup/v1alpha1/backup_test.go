@@ -0,0 +1,81 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDestinations(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    EtcdBackupSpec
+		wantErr string
+	}{{
+		name: "legacy spec",
+		spec: EtcdBackupSpec{StorageType: BackupStorageTypeS3},
+	}, {
+		name:    "legacy spec without storage type",
+		spec:    EtcdBackupSpec{},
+		wantErr: "storage type not set",
+	}, {
+		name: "destinations",
+		spec: EtcdBackupSpec{Destinations: []BackupDestination{
+			{Name: "primary", StorageType: BackupStorageTypeS3},
+			{Name: "secondary", StorageType: BackupStorageTypeGCS},
+		}},
+	}, {
+		name: "destination without storage type",
+		spec: EtcdBackupSpec{Destinations: []BackupDestination{
+			{Name: "primary"},
+		}},
+		wantErr: "destination (primary) has no storage type",
+	}, {
+		name: "destination without name",
+		spec: EtcdBackupSpec{Destinations: []BackupDestination{
+			{StorageType: BackupStorageTypeS3},
+		}},
+		wantErr: "has no name",
+	}, {
+		name: "duplicate name",
+		spec: EtcdBackupSpec{Destinations: []BackupDestination{
+			{Name: "primary", StorageType: BackupStorageTypeS3},
+			{Name: "primary", StorageType: BackupStorageTypeGCS},
+		}},
+		wantErr: "duplicate destination name",
+	}, {
+		name: "duplicate storage type",
+		spec: EtcdBackupSpec{Destinations: []BackupDestination{
+			{Name: "primary", StorageType: BackupStorageTypeS3},
+			{Name: "secondary", StorageType: BackupStorageTypeS3},
+		}},
+		wantErr: "more than one destination of storage type",
+	}}
+	for _, tt := range tests {
+		err := tt.spec.ValidateDestinations()
+		if len(tt.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestForDestination(t *testing.T) {
+	sp := EtcdBackupSpec{
+		StorageType: BackupStorageTypeS3,
+		Destinations: []BackupDestination{
+			{Name: "secondary", StorageType: BackupStorageTypeGCS, StorageSource: StorageSource{GCS: &GCSSource{Bucket: "b"}}},
+		},
+	}
+	got := sp.ForDestination(sp.Destinations[0])
+	if got.StorageType != BackupStorageTypeGCS || got.GCS == nil || len(got.Destinations) != 0 {
+		t.Errorf("unexpected spec for destination: %+v", got)
+	}
+	if sp.StorageType != BackupStorageTypeS3 || len(sp.Destinations) != 1 {
+		t.Errorf("ForDestination modified the spec: %+v", sp)
+	}
+}
@@ -9,7 +9,11 @@ import (
 const (
 	CRDResourceKind   = "EtcdBackup"
 	CRDResourcePlural = "etcdbackups"
-	groupName         = "etcd.database.coreos.com"
+
+	RestoreCRDResourceKind   = "EtcdRestore"
+	RestoreCRDResourcePlural = "etcdrestores"
+
+	groupName = "etcd.database.coreos.com"
 )
 
 var (
@@ -20,6 +24,9 @@ var (
 	SchemeGroupVersion   = schema.GroupVersion{Group: groupName, Version: "v1alpha1"}
 	CRDName              = CRDResourcePlural + "." + groupName
 	CRDResourceShortName = []string{"eb"}
+
+	RestoreCRDName              = RestoreCRDResourcePlural + "." + groupName
+	RestoreCRDResourceShortName = []string{"er"}
 )
 
 // addKnownTypes adds the set of types defined in this package to the supplied scheme.
@@ -27,6 +34,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&EtcdBackup{},
 		&EtcdBackupList{},
+		&EtcdRestore{},
+		&EtcdRestoreList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
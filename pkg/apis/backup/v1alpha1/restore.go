@@ -0,0 +1,93 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type EtcdRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []EtcdRestore `json:"items"`
+}
+
+// EtcdRestore seeds a new etcd cluster from a backup taken by an EtcdBackup.
+// The seed member pod and the PVC it keeps its data on are owned by the EtcdRestore,
+// so deleting the EtcdRestore deletes them. While the restore runs, the operator recreates
+// the seed member pod from the PVC if it goes away, for example when it is evicted.
+// Once the restore completed, the operator leaves the cluster to its owner.
+type EtcdRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              EtcdRestoreSpec   `json:"spec"`
+	Status            EtcdRestoreStatus `json:"status,omitempty"`
+}
+
+type EtcdRestoreSpec struct {
+	// BackupName is the name of the EtcdBackup in the same namespace
+	// whose storage the backup is restored from.
+	BackupName string `json:"backupName"`
+
+	// ClusterName is the name of the new etcd cluster seeded from the backup.
+	// It must not be the name of an existing cluster.
+	ClusterName string `json:"clusterName"`
+
+	// Key selects the backup with the given name.
+	Key string `json:"key,omitempty"`
+	// Revision selects the backup taken at the given etcd revision.
+	// If neither Key nor Revision is set, the latest backup is restored.
+	Revision int64 `json:"revision,omitempty"`
+
+	// Destination is the name of the destination of the EtcdBackup to restore from.
+	// Defaults to its first destination.
+	Destination string `json:"destination,omitempty"`
+
+	// EtcdVersion is the etcd version the new cluster runs.
+	// Defaults to DefaultEtcdVersion.
+	EtcdVersion string `json:"etcdVersion,omitempty"`
+
+	// StorageClassName is the StorageClass of the PVC the seed member keeps its data on.
+	// Defaults to the default StorageClass of the Kubernetes cluster.
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// VolumeSizeInMB is the size of the PVC the seed member keeps its data on.
+	// Defaults to DefaultSeedVolumeSizeInMB.
+	VolumeSizeInMB int `json:"volumeSizeInMB,omitempty"`
+}
+
+const (
+	// DefaultEtcdVersion is the etcd version restored clusters run by default.
+	DefaultEtcdVersion = "3.1.10"
+	// DefaultSeedVolumeSizeInMB is the default size of the PVC of the seed member.
+	DefaultSeedVolumeSizeInMB = 1024
+)
+
+type RestorePhase string
+
+const (
+	RestorePhaseNone RestorePhase = ""
+	// RestorePhasePending means the restore was accepted and the seed member is being created.
+	RestorePhasePending RestorePhase = "Pending"
+	// RestorePhaseRestoring means the seed member is restoring the backup.
+	RestorePhaseRestoring RestorePhase = "Restoring"
+	// RestorePhaseCompleted means the seed member serves the restored data.
+	// The operator no longer manages the seed member.
+	RestorePhaseCompleted RestorePhase = "Completed"
+	RestorePhaseFailed    RestorePhase = "Failed"
+)
+
+type EtcdRestoreStatus struct {
+	// Phase is the phase of the restore.
+	Phase RestorePhase `json:"phase,omitempty"`
+	// Reason explains why the restore is in the current phase.
+	Reason string `json:"reason,omitempty"`
+	// RestoredBackupName is the name of the backup the cluster was seeded from.
+	RestoredBackupName string `json:"restoredBackupName,omitempty"`
+	// StartTime is the time the restore started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time the restore completed or failed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// IsFinished tells whether the restore has completed or failed.
+func (rs *EtcdRestoreStatus) IsFinished() bool {
+	return rs.Phase == RestorePhaseCompleted || rs.Phase == RestorePhaseFailed
+}
@@ -0,0 +1,139 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RecordSuccess records a successful backup attempt.
+func (s *EtcdBackupStatus) RecordSuccess(r BackupRecord) {
+	r.Succeeded = true
+	s.LastSuccessTime = &r.Time
+	s.LastUpToDateTime = &r.Time
+	s.LastSuccessRevision = r.Revision
+	s.EtcdVersion = r.EtcdVersion
+	s.LastBackupName = r.Name
+	s.LastBackupSize = r.Size
+	s.LastError = ""
+	s.LastErrorTime = nil
+	s.appendHistory(r)
+	failed := s.recordDestinations(r)
+
+	s.SetCondition(BackupConditionReady, v1.ConditionTrue, BackupReasonBackupSucceeded, "")
+	if len(failed) != 0 {
+		msg := fmt.Sprintf("failed to write backup to destinations: %s", strings.Join(failed, ", "))
+		s.SetCondition(BackupConditionDegraded, v1.ConditionTrue, BackupReasonDestinationFailed, msg)
+	} else {
+		s.SetCondition(BackupConditionDegraded, v1.ConditionFalse, BackupReasonBackupSucceeded, "")
+	}
+	s.SetCondition(BackupConditionStale, v1.ConditionFalse, BackupReasonBackupSucceeded, "")
+}
+
+// RecordUpToDate records that the newest backup still reflected the cluster at t.
+func (s *EtcdBackupStatus) RecordUpToDate(t metav1.Time) {
+	s.LastUpToDateTime = &t
+	s.SetCondition(BackupConditionStale, v1.ConditionFalse, BackupReasonBackupUpToDate, "")
+}
+
+// RecordFailure records a failed backup attempt.
+func (s *EtcdBackupStatus) RecordFailure(r BackupRecord) {
+	r.Succeeded = false
+	s.LastError = r.Error
+	s.LastErrorTime = &r.Time
+	s.appendHistory(r)
+	s.recordDestinations(r)
+
+	s.SetCondition(BackupConditionReady, v1.ConditionFalse, BackupReasonBackupFailed, r.Error)
+	s.SetCondition(BackupConditionDegraded, v1.ConditionTrue, BackupReasonBackupFailed, r.Error)
+}
+
+// RecordVerification records the result of a backup verification.
+func (s *EtcdBackupStatus) RecordVerification(r VerificationResult) {
+	s.LastVerification = &r
+	if r.Succeeded {
+		msg := fmt.Sprintf("restored backup %s at revision %d", r.BackupName, r.Revision)
+		s.SetCondition(BackupConditionVerified, v1.ConditionTrue, BackupReasonVerificationSucceeded, msg)
+		return
+	}
+	s.SetCondition(BackupConditionVerified, v1.ConditionFalse, BackupReasonVerificationFailed, r.Error)
+}
+
+func (s *EtcdBackupStatus) appendHistory(r BackupRecord) {
+	s.History = append([]BackupRecord{r}, s.History...)
+	if len(s.History) > MaxBackupHistory {
+		s.History = s.History[:MaxBackupHistory]
+	}
+}
+
+// recordDestinations updates the state of the destinations r was written to,
+// and returns the names of those that failed.
+func (s *EtcdBackupStatus) recordDestinations(r BackupRecord) []string {
+	var failed []string
+	for _, dr := range r.Destinations {
+		ds := s.getDestination(dr.Name)
+		if len(dr.Error) != 0 {
+			ds.LastError = dr.Error
+			ds.LastErrorTime = &r.Time
+			failed = append(failed, dr.Name)
+			continue
+		}
+		ds.LastSuccessTime = &r.Time
+		ds.LastError = ""
+		ds.LastErrorTime = nil
+	}
+	return failed
+}
+
+func (s *EtcdBackupStatus) getDestination(name string) *DestinationStatus {
+	for i := range s.Destinations {
+		if s.Destinations[i].Name == name {
+			return &s.Destinations[i]
+		}
+	}
+	s.Destinations = append(s.Destinations, DestinationStatus{Name: name})
+	return &s.Destinations[len(s.Destinations)-1]
+}
+
+// GetCondition returns the condition of type t, or nil if there is none.
+func (s *EtcdBackupStatus) GetCondition(t BackupConditionType) *BackupCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition sets the condition of type t.
+// LastTransitionTime is only changed if the status of the condition changes.
+func (s *EtcdBackupStatus) SetCondition(t BackupConditionType, status v1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
+	c := s.GetCondition(t)
+	if c == nil {
+		s.Conditions = append(s.Conditions, BackupCondition{
+			Type:               t,
+			Status:             status,
+			LastUpdateTime:     now,
+			LastTransitionTime: now,
+			Reason:             reason,
+			Message:            message,
+		})
+		return
+	}
+	if c.Status != status {
+		c.LastTransitionTime = now
+	}
+	c.Status = status
+	c.LastUpdateTime = now
+	c.Reason = reason
+	c.Message = message
+}
+
+// IsConditionTrue tells whether the condition of type t exists and is true.
+func (s *EtcdBackupStatus) IsConditionTrue(t BackupConditionType) bool {
+	c := s.GetCondition(t)
+	return c != nil && c.Status == v1.ConditionTrue
+}
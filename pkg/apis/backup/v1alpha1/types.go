@@ -1,9 +1,19 @@
 package v1alpha1
 
 import (
+	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// MaxBackupHistory is the maximum number of backup attempts kept in EtcdBackupStatus.History.
+	MaxBackupHistory = 10
+
+	// DefaultStaleAfterIntervals is the default number of backup intervals
+	// after which the last good backup is considered stale.
+	DefaultStaleAfterIntervals = 3
+)
+
 type EtcdBackupList struct {
 	metav1.TypeMeta `json:",inline"`
 	metav1.ListMeta `json:"metadata"`
@@ -25,11 +35,232 @@ type EtcdBackupSpec struct {
 
 	StorageSource `json:",inline"`
 
+	// Destinations are the storages each backup is written to.
+	// If set, StorageType and the storage source above are ignored.
+	// At most one destination of each storage type is allowed.
+	// Destinations are written in parallel; one that fails or stalls is dropped
+	// from the backup without holding back the others.
+	Destinations []BackupDestination `json:"destinations,omitempty"`
+
+	// ClientTLSSecret is the name of the secret that holds the client certificate,
+	// key and CA used to talk to an etcd cluster that serves clients over TLS.
+	// The file names MUST be 'etcd-client.crt', 'etcd-client.key' and 'etcd-client-ca.crt'.
+	ClientTLSSecret string `json:"clientTLSSecret,omitempty"`
+
 	// BackupIntervalInSecond specifies the interval between two backups.
+	// It is ignored if Schedule is set.
 	BackupIntervalInSecond int `json:"backupIntervalInSecond"`
+
+	// Schedule specifies when backups are taken in standard cron syntax,
+	// e.g. "5 * * * *" takes a backup every hour at minute 5.
+	Schedule string `json:"schedule,omitempty"`
+	// TimeZone is the IANA time zone Schedule is interpreted in, e.g. "Europe/Berlin".
+	// Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// StaleAfterIntervals is the number of backup intervals after which the last
+	// good backup is considered stale and the Stale condition becomes true.
+	// Defaults to DefaultStaleAfterIntervals.
+	StaleAfterIntervals int `json:"staleAfterIntervals,omitempty"`
+
+	// MemberSelection decides which etcd member snapshots are taken from.
+	// It is one of "maxRevision", "preferFollower" or "preferLeader".
+	// With "preferFollower" and "preferLeader", the member with the highest revision is used
+	// if no member of the preferred role is reachable.
+	// Defaults to "maxRevision". Ignored if SnapshotMember is set.
+	MemberSelection string `json:"memberSelection,omitempty"`
+
+	// SnapshotMember is the name of the etcd member snapshots are taken from.
+	// It isn't substituted: the backup fails if the member isn't reachable.
+	SnapshotMember string `json:"snapshotMember,omitempty"`
+
+	// Compression is the compression backups are saved with.
+	// It is one of "none", "gzip" or "zstd". Defaults to "none".
+	Compression string `json:"compression,omitempty"`
+
+	// Encryption enables client-side encryption of backups.
+	// If not set, backups are saved in plaintext.
+	Encryption *EncryptionPolicy `json:"encryption,omitempty"`
+
+	// Verification enables restoring backups into a temporary etcd to check that they are usable.
+	// If not set, backups are not verified.
+	Verification *VerificationPolicy `json:"verification,omitempty"`
+
+	// Retention specifies which old backups are pruned after each successful backup.
+	// If not set, all backups are kept.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// BackupDestination is a storage that backups are written to.
+type BackupDestination struct {
+	// Name identifies the destination in the status.
+	Name string `json:"name"`
+
+	StorageType string `json:"storageType"`
+
+	StorageSource `json:",inline"`
+}
+
+// EncryptionPolicy defines how backups are encrypted.
+// Each backup is encrypted with AES-GCM under its own data key, which is wrapped
+// with the key named KeyID and stored alongside the backup.
+// KeyID is also stored as the "encryptionkeyid" metadata of backups saved to
+// object storage.
+type EncryptionPolicy struct {
+	// KeySecret is the name of the Secret holding the keys.
+	// Its data maps key IDs to 32 byte AES keys. Keys that backups were encrypted with
+	// must stay in the Secret after rotation for those backups to be restorable.
+	KeySecret string `json:"keySecret"`
+	// KeyID is the key in KeySecret that new backups are encrypted with.
+	KeyID string `json:"keyID"`
+}
+
+// VerificationPolicy defines when backups are verified.
+// A backup is verified by restoring it into an embedded etcd on the sidecar,
+// which needs local disk space for a copy of the database.
+type VerificationPolicy struct {
+	// Schedule is a cron expression, in the TimeZone of the spec, of when the latest backup is verified.
+	// If not set, each backup is verified in the background after it is saved. Backups saved
+	// while another one is verified wait, and only the newest of them is verified.
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// RetentionPolicy limits the backups kept in storage.
+// The newest backup is never pruned.
+type RetentionPolicy struct {
+	// MaxBackups is the maximum number of backups to keep.
+	// 0 means no limit.
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// MaxAge is the maximum age of backups to keep, e.g. "168h".
+	// If not set, backups are kept regardless of age.
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
 }
 
 type EtcdBackupStatus struct {
-	// Initialized indicates if the Vault service is initialized.
-	Initialized bool `json:"initialized"`
+	// LastSuccessTime is the time of the last successful backup.
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// LastUpToDateTime is the last time the newest backup was known to reflect the cluster,
+	// either because it was just taken or because nothing changed since.
+	LastUpToDateTime *metav1.Time `json:"lastUpToDateTime,omitempty"`
+	// LastSuccessRevision is the etcd revision of the last successful backup.
+	LastSuccessRevision int64 `json:"lastSuccessRevision,omitempty"`
+	// EtcdVersion is the version of the etcd member the last successful backup was taken from.
+	EtcdVersion string `json:"etcdVersion,omitempty"`
+	// LastBackupName is the object key of the last successful backup.
+	LastBackupName string `json:"lastBackupName,omitempty"`
+	// LastBackupSize is the size in bytes of the last successful backup.
+	LastBackupSize int64 `json:"lastBackupSize,omitempty"`
+
+	// LastError is the error of the last failed backup attempt.
+	// It is cleared once a backup succeeds.
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorTime is the time of the last failed backup attempt.
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+
+	// History holds the most recent backup attempts, newest first.
+	// At most MaxBackupHistory attempts are kept.
+	History []BackupRecord `json:"history,omitempty"`
+
+	// Destinations holds the state of each destination backups are written to.
+	Destinations []DestinationStatus `json:"destinations,omitempty"`
+
+	// LastVerification is the result of the last verification of a backup.
+	LastVerification *VerificationResult `json:"lastVerification,omitempty"`
+
+	// NextScheduledTime is the time of the next scheduled backup.
+	NextScheduledTime *metav1.Time `json:"nextScheduledTime,omitempty"`
+
+	// Conditions represent the latest available observations of the backup's state.
+	Conditions []BackupCondition `json:"conditions,omitempty"`
+}
+
+// BackupRecord describes a single backup attempt.
+type BackupRecord struct {
+	Time      metav1.Time `json:"time"`
+	Succeeded bool        `json:"succeeded"`
+	Revision  int64       `json:"revision,omitempty"`
+	// EtcdVersion is the version of the etcd member the snapshot was taken from.
+	EtcdVersion string `json:"etcdVersion,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Destinations holds the result of writing the backup to each destination.
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+}
+
+// VerificationResult is the result of restoring a backup into a temporary etcd.
+type VerificationResult struct {
+	Time       metav1.Time `json:"time"`
+	Succeeded  bool        `json:"succeeded"`
+	BackupName string      `json:"backupName,omitempty"`
+	// Revision is the revision of the restored etcd.
+	Revision int64 `json:"revision,omitempty"`
+	// KeyCount is the number of keys in the restored etcd.
+	KeyCount int64 `json:"keyCount,omitempty"`
+	// HashKV is the hash of the key-value store of the restored etcd at Revision.
+	HashKV uint32 `json:"hashKV,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DestinationResult is the result of writing a backup to one destination.
+type DestinationResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// DestinationStatus is the state of one destination backups are written to.
+type DestinationStatus struct {
+	Name string `json:"name"`
+	// LastSuccessTime is the time a backup was last written to the destination.
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// LastError is the error of the last failed write to the destination.
+	// It is cleared once a write succeeds.
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorTime is the time of the last failed write to the destination.
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+}
+
+type BackupConditionType string
+
+const (
+	// BackupConditionReady is true when the last backup attempt succeeded.
+	BackupConditionReady BackupConditionType = "Ready"
+	// BackupConditionDegraded is true when the backup sidecar can't be set up,
+	// the last backup attempt failed or it failed to reach some of the destinations.
+	BackupConditionDegraded BackupConditionType = "Degraded"
+	// BackupConditionVerified is true when the last verified backup could be restored.
+	BackupConditionVerified BackupConditionType = "Verified"
+	// BackupConditionStale is true when the backups haven't been up to date for
+	// StaleAfterIntervals backup intervals. It is computed by the operator,
+	// so it also turns true when the sidecar isn't running.
+	BackupConditionStale BackupConditionType = "Stale"
+)
+
+const (
+	BackupReasonSidecarCreated        = "SidecarCreated"
+	BackupReasonSidecarFailed         = "SidecarFailed"
+	BackupReasonWaitingForFirstBackup = "WaitingForFirstBackup"
+	BackupReasonBackupSucceeded       = "BackupSucceeded"
+	BackupReasonBackupFailed          = "BackupFailed"
+	BackupReasonBackupUpToDate        = "BackupUpToDate"
+	BackupReasonBackupOutdated        = "BackupOutdated"
+	BackupReasonDestinationFailed     = "DestinationFailed"
+	BackupReasonVerificationSucceeded = "VerificationSucceeded"
+	BackupReasonVerificationFailed    = "VerificationFailed"
+)
+
+// BackupCondition represents one current condition of an EtcdBackup.
+type BackupCondition struct {
+	// Type of the condition.
+	Type BackupConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status v1.ConditionStatus `json:"status"`
+	// The last time this condition was updated.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
 }
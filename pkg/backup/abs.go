@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/backup/abs"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+)
+
+const (
+	ABSV1 = "v1"
+)
+
+func init() {
+	RegisterBackend(api.BackupStorageTypeABS, newABSBackend)
+}
+
+func ToABSPrefix(absPrefix, namespace, clusterName string) string {
+	return path.Join(absPrefix, ABSV1, namespace, clusterName)
+}
+
+type absBackend struct {
+	ABS *abs.ABS
+}
+
+func newABSBackend(sp api.EtcdBackupSpec, namespace, clusterName, tmpDir string) (Backend, error) {
+	if sp.ABS == nil {
+		return nil, errors.New("abs storage source is not set")
+	}
+	creds, err := readABSCredentials(constants.ABSCredentialDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ABS credentials: %v", err)
+	}
+	abscli, err := abs.New(sp.ABS.Container, ToABSPrefix(sp.ABS.Prefix, namespace, clusterName), creds, abs.Options{
+		BaseURL:    sp.ABS.BaseURL,
+		APIVersion: sp.ABS.APIVersion,
+		UseHTTP:    sp.ABS.UseHTTP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &absBackend{ABS: abscli}, nil
+}
+
+// readABSCredentials reads the storage account name and either its key or a SAS token from dir.
+func readABSCredentials(dir string) (abs.Credentials, error) {
+	var creds abs.Credentials
+	for file, v := range map[string]*string{
+		constants.ABSStorageAccountFile: &creds.AccountName,
+		constants.ABSStorageKeyFile:     &creds.AccountKey,
+		constants.ABSSASTokenFile:       &creds.SASToken,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return creds, err
+		}
+		*v = strings.TrimSpace(string(b))
+	}
+	if len(creds.AccountName) == 0 {
+		return creds, fmt.Errorf("%s not set", constants.ABSStorageAccountFile)
+	}
+	if len(creds.AccountKey) == 0 && len(creds.SASToken) == 0 {
+		return creds, fmt.Errorf("neither %s nor %s set", constants.ABSStorageKeyFile, constants.ABSSASTokenFile)
+	}
+	return creds, nil
+}
+
+func (ab *absBackend) Save(key string, r io.Reader, meta map[string]string) (int64, error) {
+	// Blocks are uploaded as they are read, so the snapshot is streamed directly.
+	return ab.ABS.Put(key, r, meta)
+}
+
+func (ab *absBackend) List() ([]string, error) {
+	return ab.ABS.List()
+}
+
+func (ab *absBackend) Get(key string) (io.ReadCloser, error) {
+	rc, err := ab.ABS.Get(key)
+	if abs.IsNotFound(err) {
+		return nil, &notFoundError{name: key}
+	}
+	return rc, err
+}
+
+func (ab *absBackend) Delete(key string) error {
+	return ab.ABS.Delete(key)
+}
+
+func (ab *absBackend) TotalSize() (int64, error) {
+	return ab.ABS.TotalSize()
+}
+
+func (ab *absBackend) LastModified(key string) (time.Time, error) {
+	return ab.ABS.LastModified(key)
+}
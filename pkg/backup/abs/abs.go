@@ -0,0 +1,210 @@
+package abs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// blockSize is the size of the blocks snapshots are uploaded in.
+// A block blob holds at most 50000 blocks.
+const blockSize = 4 * 1024 * 1024
+
+// ABS is a helper layer to wrap complex Azure Blob Storage logic.
+type ABS struct {
+	prefix    string
+	container *storage.Container
+}
+
+// Credentials authenticate to a storage account with either the account key or a SAS token.
+type Credentials struct {
+	AccountName string
+	AccountKey  string
+	SASToken    string
+}
+
+// Options configure how the storage service is reached.
+type Options struct {
+	// BaseURL is the domain of the storage service. Defaults to the public Azure cloud.
+	BaseURL string
+	// APIVersion is the version of the storage service API used with an account key.
+	// Defaults to storage.DefaultAPIVersion.
+	APIVersion string
+	// UseHTTP talks to the storage service over HTTP instead of HTTPS, e.g. to an emulator.
+	UseHTTP bool
+	// HTTPClient sends the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns an ABS translator for container in the storage account of creds.
+func New(container, prefix string, creds Credentials, opts Options) (*ABS, error) {
+	baseURL := opts.BaseURL
+	if len(baseURL) == 0 {
+		baseURL = storage.DefaultBaseURL
+	}
+	apiVersion := opts.APIVersion
+	if len(apiVersion) == 0 {
+		apiVersion = storage.DefaultAPIVersion
+	}
+	scheme := "https"
+	if opts.UseHTTP {
+		scheme = "http"
+	}
+	hc := opts.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	var cli storage.Client
+	var err error
+	if len(creds.SASToken) != 0 {
+		endpoint := fmt.Sprintf("%s://%s.blob.%s", scheme, creds.AccountName, baseURL)
+		cli, err = storage.NewAccountSASClientFromEndpointToken(endpoint, creds.SASToken)
+		// The SAS client takes its scheme from the protocols the token allows, rather than
+		// from the endpoint, and sends the token over HTTP if the token allows it.
+		c := *hc
+		c.Transport = &schemeTransport{scheme: scheme, rt: hc.Transport}
+		hc = &c
+	} else {
+		cli, err = storage.NewClient(creds.AccountName, creds.AccountKey, baseURL, apiVersion, !opts.UseHTTP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new ABS client failed: %v", err)
+	}
+	cli.HTTPClient = hc
+	bs := cli.GetBlobService()
+
+	return NewFromContainer(bs.GetContainerReference(container), prefix), nil
+}
+
+// schemeTransport sends requests with scheme.
+type schemeTransport struct {
+	scheme string
+	rt     http.RoundTripper
+}
+
+func (t *schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.rt
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if req.URL.Scheme == t.scheme {
+		return rt.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Scheme = t.scheme
+	r.URL = &u
+	return rt.RoundTrip(r)
+}
+
+func NewFromContainer(container *storage.Container, prefix string) *ABS {
+	return &ABS{
+		prefix:    prefix,
+		container: container,
+	}
+}
+
+func (a *ABS) blob(key string) *storage.Blob {
+	return a.container.GetBlobReference(path.Join(a.prefix, key))
+}
+
+// Put uploads the content of r as a block blob, one block at a time.
+// The blob only changes when the block list is committed after all blocks are uploaded.
+// Uncommitted blocks of a failed upload are garbage collected by the service.
+// meta is committed along with the block list.
+func (a *ABS) Put(key string, r io.Reader, meta map[string]string) (int64, error) {
+	blob := a.blob(key)
+	blob.Metadata = meta
+	buf := make([]byte, blockSize)
+	var blocks []storage.Block
+	var size int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			// Block IDs of a blob must all have the same length.
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blocks))))
+			if perr := blob.PutBlock(id, buf[:n], nil); perr != nil {
+				return -1, perr
+			}
+			blocks = append(blocks, storage.Block{ID: id, Status: storage.BlockStatusUncommitted})
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+	if err := blob.PutBlockList(blocks, nil); err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+func (a *ABS) Get(key string) (io.ReadCloser, error) {
+	return a.blob(key).Get(nil)
+}
+
+// IsNotFound tells whether err is the error Get returns for keys that don't exist.
+func IsNotFound(err error) bool {
+	serr, ok := err.(storage.AzureStorageServiceError)
+	return ok && serr.StatusCode == http.StatusNotFound
+}
+
+func (a *ABS) LastModified(key string) (time.Time, error) {
+	blob := a.blob(key)
+	if err := blob.GetProperties(nil); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Time(blob.Properties.LastModified), nil
+}
+
+func (a *ABS) Delete(key string) error {
+	return a.blob(key).Delete(nil)
+}
+
+func (a *ABS) List() ([]string, error) {
+	_, l, err := a.list(a.prefix)
+	return l, err
+}
+
+// list returns the total size and the keys of the blobs under prefix.
+// It walks every page of the listing.
+func (a *ABS) list(prefix string) (int64, []string, error) {
+	// ABS doesn't have dir. It only recognizes prefix.
+	dir := prefix + "/"
+	keys := []string{}
+	var size int64
+	params := storage.ListBlobsParameters{Prefix: dir}
+	for {
+		resp, err := a.container.ListBlobs(params)
+		if err != nil {
+			return -1, nil, err
+		}
+		for _, b := range resp.Blobs {
+			keys = append(keys, strings.TrimPrefix(b.Name, dir))
+			size += b.Properties.ContentLength
+		}
+		if len(resp.NextMarker) == 0 {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+
+	return size, keys, nil
+}
+
+func (a *ABS) TotalSize() (int64, error) {
+	size, _, err := a.list(a.prefix)
+	return size, err
+}
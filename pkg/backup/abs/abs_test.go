@@ -0,0 +1,471 @@
+package abs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+)
+
+const (
+	testAccount   = "etcdbackups"
+	testContainer = "snapshots"
+	testPrefix    = "v1/default/example"
+	testBaseURL   = "core.example.net"
+
+	// maxResults is the most blobs the Blob service returns in one page of a listing.
+	maxResults = 5000
+)
+
+var testKey = []byte("etcd-backup-operator-account-key")
+
+type blob struct {
+	data     []byte
+	metadata map[string]string
+	modified time.Time
+}
+
+// blobService stands in for the Blob service of testAccount, holding the block blobs
+// of testContainer. Requests are authenticated with the account key, unless sas is set:
+// then they must carry the parameters of the SAS token sas instead.
+type blobService struct {
+	t   *testing.T
+	sas url.Values
+
+	mu    sync.Mutex
+	blobs map[string]*blob
+	// blocks holds the uncommitted blocks of each blob by block ID.
+	blocks map[string]map[string][]byte
+	// pages counts the pages of listings served.
+	pages int
+}
+
+func newBlobService(t *testing.T, sas url.Values) *blobService {
+	return &blobService{t: t, sas: sas, blobs: map[string]*blob{}, blocks: map[string]map[string][]byte{}}
+}
+
+func (bs *blobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := bs.authenticate(r); err != nil {
+		bs.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		serviceError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	q := r.URL.Query()
+	if r.URL.Path == "/"+testContainer {
+		if r.Method == http.MethodGet && q.Get("restype") == "container" && q.Get("comp") == "list" {
+			bs.listBlobs(w, q)
+			return
+		}
+		serviceError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/"+testContainer+"/")
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		bs.putBlock(w, r, name, q.Get("blockid"))
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		bs.putBlockList(w, r, name)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := bs.blobs[name]
+		if !ok {
+			serviceError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+		w.Header().Set("Last-Modified", b.modified.Format(http.TimeFormat))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		for k, v := range b.metadata {
+			w.Header().Set("x-ms-meta-"+k, v)
+		}
+		if r.Method == http.MethodGet {
+			w.Write(b.data)
+		}
+	case r.Method == http.MethodDelete:
+		if _, ok := bs.blobs[name]; !ok {
+			serviceError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(bs.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		serviceError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+// authenticate checks the SAS token parameters of r if the service has a SAS token,
+// and the Shared Key signature of r otherwise.
+func (bs *blobService) authenticate(r *http.Request) error {
+	if bs.sas != nil {
+		if auth := r.Header.Get("Authorization"); len(auth) != 0 {
+			return fmt.Errorf("unexpected authorization %q with a SAS token", auth)
+		}
+		q := r.URL.Query()
+		for k := range bs.sas {
+			if q.Get(k) != bs.sas.Get(k) {
+				return fmt.Errorf("SAS token parameter %s is %q, want %q", k, q.Get(k), bs.sas.Get(k))
+			}
+		}
+		return nil
+	}
+
+	if v := r.Header.Get("x-ms-version"); v != storage.DefaultAPIVersion {
+		return fmt.Errorf("API version %q, want %s", v, storage.DefaultAPIVersion)
+	}
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write([]byte(stringToSign(r)))
+	want := "SharedKey " + testAccount + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if auth := r.Header.Get("Authorization"); auth != want {
+		return fmt.Errorf("authorization %q, want %q", auth, want)
+	}
+	return nil
+}
+
+// stringToSign returns the string the Shared Key signature of r is computed over.
+// See https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func stringToSign(r *http.Request) string {
+	var contentLength string
+	if r.ContentLength > 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+	var msHeaders []string
+	for k := range r.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+r.Header.Get(k))
+		}
+	}
+	sort.Strings(msHeaders)
+	resource := "/" + testAccount + r.URL.EscapedPath()
+	q := r.URL.Query()
+	var params []string
+	for k := range q {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		resource += "\n" + k + ":" + strings.Join(q[k], ",")
+	}
+	h := r.Header
+	return strings.Join([]string{
+		r.Method,
+		h.Get("Content-Encoding"), h.Get("Content-Language"), contentLength, h.Get("Content-MD5"),
+		h.Get("Content-Type"), "", h.Get("If-Modified-Since"), h.Get("If-Match"),
+		h.Get("If-None-Match"), h.Get("If-Unmodified-Since"), h.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+}
+
+func (bs *blobService) putBlock(w http.ResponseWriter, r *http.Request, name, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		serviceError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+	if bs.blocks[name] == nil {
+		bs.blocks[name] = map[string][]byte{}
+	}
+	bs.blocks[name][id] = data
+	w.WriteHeader(http.StatusCreated)
+}
+
+// putBlockList commits the blocks of a blob, in the order they are listed,
+// along with the metadata in the request headers.
+func (bs *blobService) putBlockList(w http.ResponseWriter, r *http.Request, name string) {
+	var list struct {
+		Uncommitted []string `xml:"Uncommitted"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		serviceError(w, http.StatusBadRequest, "InvalidXmlDocument")
+		return
+	}
+	b := &blob{metadata: map[string]string{}, modified: time.Now().UTC().Truncate(time.Second)}
+	for _, id := range list.Uncommitted {
+		data, ok := bs.blocks[name][id]
+		if !ok {
+			serviceError(w, http.StatusBadRequest, "InvalidBlockList")
+			return
+		}
+		b.data = append(b.data, data...)
+	}
+	for k := range r.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") {
+			b.metadata[strings.TrimPrefix(k, "x-ms-meta-")] = r.Header.Get(k)
+		}
+	}
+	bs.blobs[name] = b
+	delete(bs.blocks, name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// listBlobs lists the blobs whose names start with the prefix parameter, in name order.
+// The marker of the next page names the blob it starts at.
+func (bs *blobService) listBlobs(w http.ResponseWriter, q url.Values) {
+	from := ""
+	if m := q.Get("marker"); len(m) != 0 {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(m, "2!"))
+		if err != nil {
+			serviceError(w, http.StatusBadRequest, "OutOfRangeInput")
+			return
+		}
+		from = string(b)
+	}
+	var names []string
+	for name := range bs.blobs {
+		if strings.HasPrefix(name, q.Get("prefix")) && name >= from {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	type properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ContentLength int    `xml:"Content-Length"`
+		BlobType      string `xml:"BlobType"`
+	}
+	type blobItem struct {
+		Name       string     `xml:"Name"`
+		Properties properties `xml:"Properties"`
+	}
+	resp := struct {
+		XMLName    xml.Name   `xml:"EnumerationResults"`
+		Prefix     string     `xml:"Prefix"`
+		Marker     string     `xml:"Marker"`
+		Blobs      []blobItem `xml:"Blobs>Blob"`
+		NextMarker string     `xml:"NextMarker"`
+	}{Prefix: q.Get("prefix"), Marker: q.Get("marker")}
+	if len(names) > maxResults {
+		resp.NextMarker = "2!" + base64.StdEncoding.EncodeToString([]byte(names[maxResults]))
+		names = names[:maxResults]
+	}
+	for _, name := range names {
+		b := bs.blobs[name]
+		resp.Blobs = append(resp.Blobs, blobItem{Name: name, Properties: properties{
+			LastModified:  b.modified.Format(http.TimeFormat),
+			ContentLength: len(b.data),
+			BlobType:      "BlockBlob",
+		}})
+	}
+	bs.pages++
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func serviceError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, http.StatusText(status))
+}
+
+func (bs *blobService) put(name string, data []byte) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.blobs[name] = &blob{data: data, modified: time.Now().UTC().Truncate(time.Second)}
+}
+
+func (bs *blobService) blob(name string) *blob {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.blobs[name]
+}
+
+func (bs *blobService) listedPages() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.pages
+}
+
+// accountTransport sends the requests for the Blob service of testAccount to a test server.
+type accountTransport struct {
+	addr string
+	rt   http.RoundTripper
+}
+
+func (t *accountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if want := testAccount + ".blob." + testBaseURL; req.URL.Host != want {
+		return nil, fmt.Errorf("request to %s, want %s", req.URL.Host, want)
+	}
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Host = t.addr
+	r.URL = &u
+	r.Host = ""
+	return t.rt.RoundTrip(r)
+}
+
+// newTestABS serves bs over HTTPS, or over HTTP if useHTTP is set, and returns an ABS
+// of the container it holds.
+func newTestABS(t *testing.T, bs *blobService, creds Credentials, useHTTP bool) (*ABS, func()) {
+	var srv *httptest.Server
+	if useHTTP {
+		srv = httptest.NewServer(bs)
+	} else {
+		srv = httptest.NewTLSServer(bs)
+	}
+	rt := srv.Client().Transport
+	opts := Options{
+		BaseURL:    testBaseURL,
+		UseHTTP:    useHTTP,
+		HTTPClient: &http.Client{Transport: &accountTransport{addr: srv.Listener.Addr().String(), rt: rt}},
+	}
+	a, err := New(testContainer, testPrefix, creds, opts)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return a, srv.Close
+}
+
+func accountKeyCredentials() Credentials {
+	return Credentials{AccountName: testAccount, AccountKey: base64.StdEncoding.EncodeToString(testKey)}
+}
+
+func TestABSListPages(t *testing.T) {
+	bs := newBlobService(t, nil)
+	a, done := newTestABS(t, bs, accountKeyCredentials(), false)
+	defer done()
+	n := 2*maxResults + 1
+	for rev := 1; rev <= n; rev++ {
+		bs.put(fmt.Sprintf("%s/3.2.0_%016x_etcd.backup", testPrefix, rev), make([]byte, rev%7))
+	}
+	// A cluster restored under another name keeps its backups next to the original's.
+	bs.put(testPrefix+"-restored/3.2.0_0000000000000001_etcd.backup", []byte("x"))
+
+	keys, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := bs.listedPages(); pages != 3 {
+		t.Errorf("listed %d pages, want 3", pages)
+	}
+	if len(keys) != n {
+		t.Fatalf("listed %d keys, want %d", len(keys), n)
+	}
+	if want := fmt.Sprintf("3.2.0_%016x_etcd.backup", n); keys[n-1] != want {
+		t.Errorf("last key is %s, want %s", keys[n-1], want)
+	}
+
+	size, err := a.TotalSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want int64
+	for rev := 1; rev <= n; rev++ {
+		want += int64(rev % 7)
+	}
+	if size != want {
+		t.Errorf("total size is %d, want %d", size, want)
+	}
+}
+
+func TestABSBlobs(t *testing.T) {
+	bs := newBlobService(t, nil)
+	a, done := newTestABS(t, bs, accountKeyCredentials(), false)
+	defer done()
+
+	key := "3.2.0_0000000000000007_etcd.backup.aes256gcm"
+	// The snapshot takes three blocks.
+	data := make([]byte, 2*blockSize+100)
+	for i := range data {
+		data[i] = byte(i / blockSize)
+	}
+	n, err := a.Put(key, strings.NewReader(string(data)), map[string]string{"encryptionkeyid": "key-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("put %d bytes, want %d", n, len(data))
+	}
+	b := bs.blob(testPrefix + "/" + key)
+	if b == nil {
+		t.Fatalf("blob %s/%s wasn't committed", testPrefix, key)
+	}
+	if got := b.metadata["encryptionkeyid"]; got != "key-2" {
+		t.Errorf("metadata encryptionkeyid is %q, want key-2", got)
+	}
+
+	rc, err := a.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("got %d bytes that differ from the %d bytes put", len(got), len(data))
+	}
+	lm, err := a.LastModified(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lm.Equal(b.modified) {
+		t.Errorf("last modified at %v, want %v", lm, b.modified)
+	}
+
+	if err = a.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Get(key); !IsNotFound(err) {
+		t.Errorf("Get of a deleted blob returned %v, want a not found error", err)
+	}
+}
+
+func TestABSSASToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// protocols the token allows.
+		spr     string
+		useHTTP bool
+	}{
+		{name: "https only", spr: "https"},
+		// The token is only sent over HTTP if the options ask for it.
+		{name: "https and http", spr: "https,http"},
+		{name: "http to an emulator", spr: "https,http", useHTTP: true},
+	}
+	for _, tt := range tests {
+		token := url.Values{
+			"sv":  {"2016-05-31"},
+			"ss":  {"b"},
+			"srt": {"sco"},
+			"sp":  {"rwdlac"},
+			"se":  {"2030-01-01T00:00:00Z"},
+			"spr": {tt.spr},
+			"sig": {"c2lnbmVkIGJ5IHRoZSBhY2NvdW50IGtleQ=="},
+		}
+		bs := newBlobService(t, token)
+		a, done := newTestABS(t, bs, Credentials{AccountName: testAccount, SASToken: token.Encode()}, tt.useHTTP)
+
+		key := "3.2.0_0000000000000001_etcd.backup"
+		if _, err := a.Put(key, strings.NewReader("snapshot"), nil); err != nil {
+			t.Errorf("%s: Put failed: %v", tt.name, err)
+		}
+		keys, err := a.List()
+		if err != nil || len(keys) != 1 || keys[0] != key {
+			t.Errorf("%s: listed %v, %v; want [%s]", tt.name, keys, err, key)
+		}
+		if err = a.Delete(key); err != nil {
+			t.Errorf("%s: Delete failed: %v", tt.name, err)
+		}
+		done()
+	}
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+)
+
+const (
+	backupFilePerm       = 0600
+	backupFilenameSuffix = "etcd.backup"
+)
+
+// Backend is a storage destination for backups.
+type Backend interface {
+	// Save saves the backup read from r under name and returns its size.
+	// meta is stored as object metadata by backends that support it.
+	// Save must return once reading r fails, without saving the backup: that's how
+	// a destination that stalls or is given up on is stopped.
+	Save(name string, r io.Reader, meta map[string]string) (int64, error)
+	// List returns the names of all objects stored for the cluster.
+	List() ([]string, error)
+	// Get returns a reader for the object with the given name.
+	// It returns a *notFoundError if the object doesn't exist.
+	Get(name string) (io.ReadCloser, error)
+	// Delete deletes the object with the given name.
+	Delete(name string) error
+	// TotalSize returns the size of all objects stored for the cluster.
+	TotalSize() (int64, error)
+	// LastModified returns the time the object with the given name was last modified.
+	LastModified(name string) (time.Time, error)
+}
+
+// notFoundError is returned by Backend.Get for objects that don't exist.
+type notFoundError struct {
+	name string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("object (%s) not found", e.name)
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(*notFoundError)
+	return ok
+}
+
+// BackendFactory creates a Backend for the given backup spec.
+// tmpDir is a local directory the backend may use for temporary files.
+type BackendFactory func(sp api.EtcdBackupSpec, namespace, clusterName, tmpDir string) (Backend, error)
+
+var backendFactories = map[string]BackendFactory{}
+
+// RegisterBackend makes a backend available for the given storage type.
+// It panics if a backend is registered twice for the same storage type.
+func RegisterBackend(storageType string, f BackendFactory) {
+	if _, ok := backendFactories[storageType]; ok {
+		panic(fmt.Sprintf("backend already registered for storage type %q", storageType))
+	}
+	backendFactories[storageType] = f
+}
+
+// NewBackend creates the backend registered for sp.StorageType.
+func NewBackend(sp api.EtcdBackupSpec, namespace, clusterName string) (Backend, error) {
+	f, ok := backendFactories[sp.StorageType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage type: %v", sp.StorageType)
+	}
+
+	tmpDir := path.Join(constants.BackupMountDir, "v1", clusterName, backupTmpDir)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return nil, err
+	}
+	return f(sp, namespace, clusterName, tmpDir)
+}
+
+func getLatest(be Backend) (string, error) {
+	names, err := be.List()
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups: %v", err)
+	}
+
+	return getLatestBackupName(names), nil
+}
+
+func makeBackupName(ver string, rev int64, compression string, encrypted bool) string {
+	name := fmt.Sprintf("%s_%016x_%s%s", ver, rev, backupFilenameSuffix, compressionSuffixes[compression])
+	if encrypted {
+		name += encryptedSuffix
+	}
+	return name
+}
+
+func getLatestBackupName(names []string) string {
+	bnames := filterAndSortBackups(names)
+	if len(bnames) == 0 {
+		return ""
+	}
+	return bnames[len(bnames)-1]
+}
+
+type backupNames []string
+
+func (bn backupNames) Len() int { return len(bn) }
+
+func (bn backupNames) Less(i, j int) bool {
+	ri, err := getRev(bn[i])
+	if err != nil {
+		panic(err)
+	}
+	rj, err := getRev(bn[j])
+	if err != nil {
+		panic(err)
+	}
+
+	return ri < rj
+}
+
+func (bn backupNames) Swap(i, j int) {
+	bn[i], bn[j] = bn[j], bn[i]
+}
+
+func filterAndSortBackups(names []string) []string {
+	bnames := make(backupNames, 0)
+	for _, n := range names {
+		if !isBackup(n) {
+			continue
+		}
+		_, err := getRev(n)
+		if err != nil {
+			logrus.Errorf("fail to get rev from backup (%s): %v", n, err)
+			continue
+		}
+		bnames = append(bnames, n)
+	}
+
+	sort.Sort(bnames)
+	return []string(bnames)
+}
+
+func isBackup(name string) bool {
+	return strings.HasSuffix(snapshotFileName(name), backupFilenameSuffix)
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memBackend is a Backend that keeps objects in memory.
+type memBackend struct {
+	mu      sync.Mutex
+	objects map[string]*memObject
+	// gets counts the Gets of each object.
+	gets map[string]int
+	// lists counts the calls to List.
+	lists int
+}
+
+type memObject struct {
+	data    []byte
+	meta    map[string]string
+	modTime time.Time
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{objects: map[string]*memObject{}, gets: map[string]int{}}
+}
+
+// put stores an object as if it was saved at modTime.
+func (mb *memBackend) put(name string, data []byte, modTime time.Time) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.objects[name] = &memObject{data: data, modTime: modTime}
+}
+
+func (mb *memBackend) Save(name string, r io.Reader, meta map[string]string) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return -1, err
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.objects[name] = &memObject{data: b, meta: meta, modTime: time.Now()}
+	return int64(len(b)), nil
+}
+
+func (mb *memBackend) List() ([]string, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.lists++
+	var names []string
+	for name := range mb.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (mb *memBackend) Get(name string) (io.ReadCloser, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	o, ok := mb.objects[name]
+	if !ok {
+		return nil, &notFoundError{name: name}
+	}
+	mb.gets[name]++
+	return ioutil.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (mb *memBackend) Delete(name string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if _, ok := mb.objects[name]; !ok {
+		return fmt.Errorf("object not found: %s", name)
+	}
+	delete(mb.objects, name)
+	return nil
+}
+
+func (mb *memBackend) TotalSize() (int64, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var size int64
+	for _, o := range mb.objects {
+		size += int64(len(o.data))
+	}
+	return size, nil
+}
+
+func (mb *memBackend) LastModified(name string) (time.Time, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	o, ok := mb.objects[name]
+	if !ok {
+		return time.Time{}, fmt.Errorf("object not found: %s", name)
+	}
+	return o.modTime, nil
+}
+
+// object returns the object with the given name, or nil if there is none.
+func (mb *memBackend) object(name string) *memObject {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.objects[name]
+}
@@ -15,98 +15,203 @@
 package backup
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
-	"github.com/coreos/etcd-backup-operator/pkg/backup/s3"
+	"github.com/coreos/etcd-backup-operator/pkg/backup/schedule"
+	"github.com/coreos/etcd-backup-operator/pkg/client"
 	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
 	"github.com/coreos/etcd-backup-operator/pkg/util/etcdutil"
 	k8sutil "github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	backupTmpDir = "tmp"
-	AWSS3Bucket  = "AWS_S3_BUCKET"
 )
 
 type Backup struct {
 	kclient     kubernetes.Interface
+	backupCRCli client.BackupCR
+	// name is the name of the EtcdBackup CR this sidecar serves.
+	name        string
 	spec        api.EtcdBackupSpec
 	clusterName string
 	namespace   string
-	be          *s3Backend
+	// dests are the destinations backups are written to.
+	// Backups are read from the first one.
+	dests []destination
+	sched schedule.Schedule
+	// verifySched is the schedule backups are verified on;
+	// nil if backups are verified after they are saved or not at all.
+	verifySched schedule.Schedule
+	// tlsConfig is the client TLS config to talk to etcd; nil if etcd serves clients in plaintext.
+	tlsConfig *tls.Config
+	// keys holds the keys to encrypt and decrypt backups with; nil if backups are not encrypted.
+	keys Keyring
+	// apiToken is the bearer token clients of the HTTP API must present.
+	apiToken string
+
+	// backupNowc receives on-demand backup requests from the HTTP server.
+	// The result of the backup is sent on the received channel.
+	backupNowc chan chan<- backupResult
+	// verifyc holds the name of the next backup to verify after it was saved.
+	verifyc chan string
+
+	// mu protects the fields below, which are read by the HTTP server.
+	mu                sync.Mutex
+	lastResult        *backupResult
+	nextScheduledTime time.Time
 }
 
-func New(kclient kubernetes.Interface, sp api.EtcdBackupSpec, clusterName, namespace string) (*Backup, error) {
-	bdir := path.Join(constants.BackupMountDir, "v1", clusterName)
-	tmpDir := path.Join(bdir, backupTmpDir)
-	err := os.MkdirAll(tmpDir, 0700)
+func New(kclient kubernetes.Interface, backupCRCli client.BackupCR, name string, sp api.EtcdBackupSpec, clusterName, namespace string) (*Backup, error) {
+	sched, err := schedule.New(sp)
 	if err != nil {
-
 		return nil, err
 	}
-
-	if sp.StorageType != "s3" {
-		return nil, fmt.Errorf("unsupported storage type: %v", sp.StorageType)
+	if err = validateCompression(sp.Compression); err != nil {
+		return nil, err
 	}
-
-	s3cli, err := s3.New(os.Getenv(AWSS3Bucket), ToS3Prefix(sp.S3.Prefix, namespace, clusterName))
+	if err = sp.ValidateMemberSelection(); err != nil {
+		return nil, err
+	}
+	var verifySched schedule.Schedule
+	if v := sp.Verification; v != nil && len(v.Schedule) != 0 {
+		verifySched, err = schedule.NewCron(v.Schedule, sp.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification schedule: %v", err)
+		}
+	}
+	dests, err := newDestinations(sp, namespace, clusterName)
 	if err != nil {
 		return nil, err
 	}
-
-	s3be := &s3Backend{
-		dir: tmpDir,
-		S3:  s3cli,
+	var tc *tls.Config
+	if len(sp.ClientTLSSecret) != 0 {
+		tc, err = etcdutil.NewTLSConfigFromDir(constants.EtcdClientTLSDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create etcd client TLS config: %v", err)
+		}
+	}
+	kr, err := newEncryptionKeyring(sp.Encryption, constants.EncryptionKeyDir)
+	if err != nil {
+		return nil, err
+	}
+	token := os.Getenv(constants.EnvBackupAPIToken)
+	if len(token) == 0 {
+		logrus.Warningf("%s not set; the HTTP API rejects all requests", constants.EnvBackupAPIToken)
 	}
 
 	return &Backup{
 		kclient:     kclient,
+		backupCRCli: backupCRCli,
+		name:        name,
 		spec:        sp,
 		clusterName: clusterName,
 		namespace:   namespace,
-		be:          s3be,
+		dests:       dests,
+		sched:       sched,
+		verifySched: verifySched,
+		tlsConfig:   tc,
+		keys:        kr,
+		apiToken:    token,
+		backupNowc:  make(chan chan<- backupResult),
+		verifyc:     make(chan string, 1),
 	}, nil
 }
 
-const S3V1 = "v1"
-
-func ToS3Prefix(s3Prefix, namespace, clusterName string) string {
-	return path.Join(s3Prefix, S3V1, namespace, clusterName)
-}
-
 func (b *Backup) Run() {
 	lastSnapRev := b.getLatestBackupRev()
-	interval := constants.DefaultSnapshotInterval
-	if b.spec.BackupIntervalInSecond != 0 {
-		interval = time.Duration(b.spec.BackupIntervalInSecond) * time.Second
+	go b.serveHTTP()
+	if b.verifySched != nil {
+		go b.runVerification(b.verifySched)
+	} else if b.spec.Verification != nil {
+		go b.runVerifier()
 	}
 	for {
-		<-time.After(interval)
-		rev, err := b.saveSnap(lastSnapRev)
-		if err != nil {
-			logrus.Errorf("failed to save snapshot: %v", err)
+		next := b.sched.Next(time.Now())
+		b.setNextScheduledTime(next)
+		b.reportStatus(func(s *api.EtcdBackupStatus) {
+			t := metav1.NewTime(next)
+			s.NextScheduledTime = &t
+		})
+
+		var resultc chan<- backupResult
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-timer.C:
+		case resultc = <-b.backupNowc:
+			timer.Stop()
+			logrus.Info("taking on-demand backup")
+		}
+
+		var res backupResult
+		lastSnapRev, res = b.backupOnce(lastSnapRev)
+		if resultc != nil {
+			resultc <- res
+		}
+	}
+}
+
+// backupOnce takes a backup if anything changed since lastSnapRev,
+// and returns the revision of the newest backup and the result of the attempt.
+func (b *Backup) backupOnce(lastSnapRev int64) (int64, backupResult) {
+	attemptsTotal.Inc()
+	rev, record, err := b.saveSnap(lastSnapRev)
+	var res backupResult
+	switch {
+	case err != nil:
+		logrus.Errorf("failed to save snapshot: %v", err)
+		failuresTotal.WithLabelValues(failureReason(err)).Inc()
+		res.BackupRecord = api.BackupRecord{Time: metav1.Now(), Error: err.Error()}
+		if record != nil {
+			res.Destinations = record.Destinations
+		}
+		b.reportStatus(func(s *api.EtcdBackupStatus) { s.RecordFailure(res.BackupRecord) })
+	case record == nil:
+		res.BackupRecord = api.BackupRecord{Time: metav1.Now(), Succeeded: true, Revision: rev}
+		res.Skipped = true
+		skippedNoChangeTotal.Inc()
+		b.reportStatus(func(s *api.EtcdBackupStatus) { s.RecordUpToDate(res.Time) })
+	default:
+		res.BackupRecord = *record
+		successesTotal.Inc()
+		snapshotSizeBytes.Observe(float64(record.Size))
+		lastSuccessTimestampSeconds.Set(float64(record.Time.Unix()))
+		lastSuccessRevision.Set(float64(record.Revision))
+		// Backups are verified from the first destination, which may have missed this one.
+		if b.spec.Verification != nil && b.verifySched == nil && len(record.Destinations[0].Error) == 0 {
+			b.queueVerification(record.Name)
 		}
-		lastSnapRev = rev
 	}
+	b.setLastResult(res)
+	return rev, res
 }
 
-func (b *Backup) saveSnap(lastSnapRev int64) (int64, error) {
+// saveSnap saves a new backup if the cluster changed since lastSnapRev.
+// It returns the revision of the newest backup saved to all destinations and the record
+// of the new backup, which is nil if no backup was attempted.
+func (b *Backup) saveSnap(lastSnapRev int64) (int64, *api.BackupRecord, error) {
 	podList, err := b.kclient.Core().Pods(b.namespace).List(k8sutil.ClusterListOpt(b.clusterName))
 	if err != nil {
-		return lastSnapRev, err
+		return lastSnapRev, nil, &backupError{reason: reasonListPods, err: err}
 	}
 
 	var pods []*v1.Pod
@@ -118,38 +223,53 @@ func (b *Backup) saveSnap(lastSnapRev int64) (int64, error) {
 	}
 
 	if len(pods) == 0 {
-		msg := "no running etcd pods found"
-		logrus.Warning(msg)
-		return lastSnapRev, fmt.Errorf(msg)
+		err = errors.New("no running etcd pods found")
+		logrus.Warning(err)
+		return lastSnapRev, nil, &backupError{reason: reasonNoRunningPods, err: err}
 	}
-	member, rev := getMemberWithMaxRev(pods, nil)
+	member, rev := selectMember(getMemberStatuses(pods, b.tlsConfig), b.spec.MemberSelection, b.spec.SnapshotMember)
 	if member == nil {
 		logrus.Warning("no reachable member")
-		return lastSnapRev, fmt.Errorf("no reachable member")
+		return lastSnapRev, nil, &backupError{reason: reasonNoReachableMember, err: fmt.Errorf("no reachable member")}
 	}
 
 	if rev <= lastSnapRev {
 		logrus.Info("skipped creating new backup: no change since last time")
-		return lastSnapRev, nil
+		return lastSnapRev, nil, nil
 	}
 
 	log.Printf("saving backup for cluster (%s)", b.clusterName)
-	if err := b.writeSnap(member, rev); err != nil {
-		err = fmt.Errorf("write snapshot failed: %v", err)
-		return lastSnapRev, err
+	record := api.BackupRecord{Time: metav1.Now(), Revision: rev}
+	if err = b.writeSnap(member, &record); err != nil {
+		return lastSnapRev, &record, &backupError{reason: failureReason(err), err: fmt.Errorf("write snapshot failed: %v", err)}
+	}
+	record.Succeeded = true
+	b.reportStatus(func(s *api.EtcdBackupStatus) { s.RecordSuccess(record) })
+
+	if err := b.prune(); err != nil {
+		logrus.Errorf("failed to prune backups: %v", err)
 	}
-	return rev, nil
+	for _, dr := range record.Destinations {
+		if len(dr.Error) != 0 {
+			// Retry the destinations that missed this revision on the next attempt.
+			return lastSnapRev, &record, nil
+		}
+	}
+	return rev, &record, nil
 }
 
-func (b *Backup) writeSnap(m *etcdutil.Member, rev int64) error {
+// writeSnap saves a snapshot of member m to all destinations, and fills in the etcd version of m,
+// the name and size of the saved backup and the result of each destination in record.
+// It fails only if the backup couldn't be saved to any destination.
+func (b *Backup) writeSnap(m *etcdutil.Member, record *api.BackupRecord) error {
 	cfg := clientv3.Config{
 		Endpoints:   []string{m.ClientURL()},
 		DialTimeout: constants.DefaultDialTimeout,
-		TLS:         nil,
+		TLS:         b.tlsConfig,
 	}
 	etcdcli, err := clientv3.New(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create etcd client (%v)", err)
+		return &backupError{reason: reasonSnapshot, err: fmt.Errorf("failed to create etcd client (%v)", err)}
 	}
 	defer etcdcli.Close()
 
@@ -157,77 +277,228 @@ func (b *Backup) writeSnap(m *etcdutil.Member, rev int64) error {
 	resp, err := etcdcli.Maintenance.Status(ctx, m.ClientURL())
 	cancel()
 	if err != nil {
-		return err
+		return &backupError{reason: reasonSnapshot, err: err}
 	}
 
+	start := time.Now()
 	ctx, cancel = context.WithTimeout(context.Background(), constants.DefaultSnapshotTimeout)
+	defer cancel()
 	rc, err := etcdcli.Maintenance.Snapshot(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to receive snapshot (%v)", err)
+		return &backupError{reason: reasonSnapshot, err: fmt.Errorf("failed to receive snapshot (%v)", err)}
 	}
-	defer cancel()
 	defer rc.Close()
-
-	_, err = b.be.save(resp.Version, rev, rc)
+	// etcd takes the snapshot before it sends the first bytes of it, so the revision of m
+	// once they arrived is the most the revision of the snapshot can be.
+	br := bufio.NewReaderSize(rc, fanoutChunkSize)
+	if _, err = br.Peek(1); err != nil {
+		return &backupError{reason: reasonSnapshot, err: fmt.Errorf("failed to receive snapshot (%v)", err)}
+	}
+	sctx, scancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+	after, err := etcdcli.Maintenance.Status(sctx, m.ClientURL())
+	scancel()
 	if err != nil {
-		return err
+		return &backupError{reason: reasonSnapshot, err: err}
 	}
 
+	name := makeBackupName(resp.Version, record.Revision, b.spec.Compression, b.keys != nil)
+	sr := &snapshotReader{r: br}
+	cr := compress(sr, b.spec.Compression)
+	defer cr.Close()
+	r := io.Reader(cr)
+	var meta map[string]string
+	if b.keys != nil {
+		er, err := encrypt(cr, b.keys, b.spec.Encryption.KeyID)
+		if err != nil {
+			return &backupError{reason: reasonSave, err: fmt.Errorf("failed to encrypt backup: %v", err)}
+		}
+		defer er.Close()
+		r = er
+		meta = map[string]string{encryptionKeyIDMetadataKey: b.spec.Encryption.KeyID}
+	}
+	h := sha256.New()
+	n, errs := saveToAll(b.dests, name, io.TeeReader(r, h), meta)
+	mf := &Manifest{
+		Name:        name,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		Size:        n,
+		Revision:    record.Revision,
+		MaxRevision: after.Header.Revision,
+		EtcdVersion: resp.Version,
+		ClusterID:   fmt.Sprintf("%x", resp.Header.ClusterId),
+		Member:      m.Name,
+		Time:        time.Now(),
+	}
+	var lastErr error
+	saved := 0
+	for i, d := range b.dests {
+		err := errs[i]
+		if err == nil {
+			// The manifest is saved last, so that a backup without one is known to be incomplete.
+			if err = saveManifest(d.be, mf); err != nil {
+				err = fmt.Errorf("failed to save manifest: %v", err)
+			}
+		}
+		dr := api.DestinationResult{Name: d.name}
+		if err != nil {
+			logrus.Errorf("failed to save backup %s to destination (%s): %v", name, d.name, err)
+			destinationFailuresTotal.WithLabelValues(d.name).Inc()
+			dr.Error = err.Error()
+			lastErr = err
+		} else {
+			saved++
+		}
+		record.Destinations = append(record.Destinations, dr)
+	}
+	eof, serr := sr.result()
+	if saved == 0 {
+		if serr != nil {
+			return &backupError{reason: reasonSnapshot, err: lastErr}
+		}
+		return &backupError{reason: reasonSave, err: lastErr}
+	}
+	if !eof.IsZero() {
+		snapshotDurationSeconds.Observe(eof.Sub(start).Seconds())
+		uploadDurationSeconds.Observe(time.Since(eof).Seconds())
+	}
+	logrus.Infof("saved backup %s (size: %d) to %d of %d destinations", name, n, saved, len(b.dests))
+
+	record.EtcdVersion = resp.Version
+	record.Name = name
+	record.Size = n
 	return nil
 }
 
-func getMemberWithMaxRev(pods []*v1.Pod, tc *tls.Config) (*etcdutil.Member, int64) {
-	var member *etcdutil.Member
-	maxRev := int64(0)
+// memberStatus is the state of a reachable etcd member.
+type memberStatus struct {
+	m        *etcdutil.Member
+	rev      int64
+	isLeader bool
+}
+
+// getStatus gets the status of the etcd member m. Tests replace it to fake members.
+var getStatus = func(m *etcdutil.Member, tc *tls.Config) (*clientv3.StatusResponse, error) {
+	cfg := clientv3.Config{
+		Endpoints:   []string{m.ClientURL()},
+		DialTimeout: constants.DefaultDialTimeout,
+		TLS:         tc,
+	}
+	etcdcli, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %v", err)
+	}
+	defer etcdcli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+	defer cancel()
+	return etcdcli.Maintenance.Status(ctx, m.ClientURL())
+}
+
+// getMemberStatuses returns the state of the reachable members among pods.
+func getMemberStatuses(pods []*v1.Pod, tc *tls.Config) []memberStatus {
+	var mss []memberStatus
+	// Forget about members that are gone.
+	memberRevision.Reset()
 	for _, pod := range pods {
 		m := &etcdutil.Member{
 			Name:         pod.Name,
 			Namespace:    pod.Namespace,
 			SecureClient: tc != nil,
 		}
-		cfg := clientv3.Config{
-			Endpoints:   []string{m.ClientURL()},
-			DialTimeout: constants.DefaultDialTimeout,
-			TLS:         tc,
-		}
-		etcdcli, err := clientv3.New(cfg)
+		resp, err := getStatus(m, tc)
 		if err != nil {
-			logrus.Warningf("failed to create etcd client for pod (%v): %v", pod.Name, err)
+			logrus.Warningf("getMemberStatuses: failed to get status from member %s (%s): %v", m.Name, m.ClientURL(), err)
 			continue
 		}
-		defer etcdcli.Close()
 
-		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
-		resp, err := etcdcli.Get(ctx, "/", clientv3.WithSerializable())
-		cancel()
-		if err != nil {
-			logrus.Warningf("getMaxRev: failed to get revision from member %s (%s)", m.Name, m.ClientURL())
-			continue
+		isLeader := resp.Header.MemberId == resp.Leader
+		logrus.Infof("getMemberStatuses: member %s revision (%d) leader (%v)", m.Name, resp.Header.Revision, isLeader)
+		memberRevision.WithLabelValues(m.Name).Set(float64(resp.Header.Revision))
+		mss = append(mss, memberStatus{m: m, rev: resp.Header.Revision, isLeader: isLeader})
+	}
+	return mss
+}
+
+// selectMember returns the member to take the snapshot from and its revision.
+// It is the member with the given name if set, or else chosen according to policy.
+// It returns nil if the named member isn't reachable.
+func selectMember(mss []memberStatus, policy, name string) (*etcdutil.Member, int64) {
+	if len(name) != 0 {
+		for _, ms := range mss {
+			if ms.m.Name == name {
+				return ms.m, ms.rev
+			}
+		}
+		logrus.Warningf("member %s not reachable", name)
+		return nil, 0
+	}
+	switch policy {
+	case "", api.MemberSelectionMaxRevision:
+		return maxRevMember(mss)
+	case api.MemberSelectionPreferFollower:
+		var followers []memberStatus
+		for _, ms := range mss {
+			if !ms.isLeader {
+				followers = append(followers, ms)
+			}
 		}
+		if len(followers) != 0 {
+			return maxRevMember(followers)
+		}
+		logrus.Warning("no reachable follower; falling back to the leader")
+		return maxRevMember(mss)
+	case api.MemberSelectionPreferLeader:
+		for _, ms := range mss {
+			if ms.isLeader {
+				return ms.m, ms.rev
+			}
+		}
+		logrus.Warning("leader not reachable; falling back to the member with the highest revision")
+		return maxRevMember(mss)
+	default:
+		// Rejected by ValidateMemberSelection.
+		return nil, 0
+	}
+}
 
-		logrus.Infof("getMaxRev: member %s revision (%d)", m.Name, resp.Header.Revision)
-		if resp.Header.Revision > maxRev {
-			maxRev = resp.Header.Revision
-			member = m
+func maxRevMember(mss []memberStatus) (*etcdutil.Member, int64) {
+	var member *etcdutil.Member
+	maxRev := int64(0)
+	for _, ms := range mss {
+		if ms.rev > maxRev {
+			maxRev = ms.rev
+			member = ms.m
 		}
 	}
 	return member, maxRev
 }
 
+// getLatestBackupRev returns the revision of the latest backup saved to all destinations.
 func (b *Backup) getLatestBackupRev() int64 {
-	// If there is any error, we just exit backup sidecar because we can't serve the backup any way.
-	name, err := b.be.getLatest()
-	if err != nil {
-		logrus.Fatal(err)
-	}
-	if len(name) == 0 {
-		return 0
-	}
-	rev, err := getRev(name)
-	if err != nil {
-		logrus.Fatal(err)
+	var minRev int64
+	for i, d := range b.dests {
+		// If there is any error, we just exit backup sidecar because we can't serve the backup any way.
+		name, err := getLatest(d.be)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		var rev int64
+		if len(name) != 0 {
+			rev, err = getRev(name)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		if i == 0 || rev < minRev {
+			minRev = rev
+		}
 	}
-	return rev
+	return minRev
+}
+
+// primary returns the backend backups are read from.
+func (b *Backup) primary() Backend {
+	return b.dests[0].be
 }
 
 func getRev(name string) (int64, error) {
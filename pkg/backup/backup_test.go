@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/etcdutil"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeMember is the status getStatus reports for the member of a pod.
+type fakeMember struct {
+	name     string
+	rev      int64
+	isLeader bool
+	// down makes the member unreachable.
+	down bool
+}
+
+// fakeStatuses makes getStatus report members, and returns their pods.
+// It returns a func that restores getStatus.
+func fakeStatuses(members []fakeMember) ([]*v1.Pod, func()) {
+	const leaderID = 1
+	byName := map[string]fakeMember{}
+	var pods []*v1.Pod
+	for _, fm := range members {
+		byName[fm.name] = fm
+		pods = append(pods, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fm.name, Namespace: "default"}})
+	}
+	orig := getStatus
+	getStatus = func(m *etcdutil.Member, tc *tls.Config) (*clientv3.StatusResponse, error) {
+		fm := byName[m.Name]
+		if fm.down {
+			return nil, errors.New("context deadline exceeded")
+		}
+		id := uint64(leaderID + 1)
+		if fm.isLeader {
+			id = leaderID
+		}
+		return &clientv3.StatusResponse{
+			Header: &pb.ResponseHeader{MemberId: id, Revision: fm.rev},
+			Leader: leaderID,
+		}, nil
+	}
+	return pods, func() { getStatus = orig }
+}
+
+func TestSelectMember(t *testing.T) {
+	// The leader example-0000 is behind both followers, and example-0001 is ahead of example-0002.
+	healthy := []fakeMember{{name: "example-0000", rev: 10, isLeader: true}, {name: "example-0001", rev: 12}, {name: "example-0002", rev: 11}}
+	tests := []struct {
+		name    string
+		members []fakeMember
+		policy  string
+		// member is the SnapshotMember of the spec.
+		member string
+		// want is the name of the selected member, or "" if none is.
+		want    string
+		wantRev int64
+	}{{
+		name:    "highest revision by default",
+		members: healthy,
+		want:    "example-0001",
+		wantRev: 12,
+	}, {
+		name:    "highest revision",
+		members: healthy,
+		policy:  api.MemberSelectionMaxRevision,
+		want:    "example-0001",
+		wantRev: 12,
+	}, {
+		name:    "highest revision among reachable members",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true}, {name: "example-0001", rev: 12, down: true}, {name: "example-0002", rev: 11}},
+		policy:  api.MemberSelectionMaxRevision,
+		want:    "example-0002",
+		wantRev: 11,
+	}, {
+		name:    "leader",
+		members: healthy,
+		policy:  api.MemberSelectionPreferLeader,
+		want:    "example-0000",
+		wantRev: 10,
+	}, {
+		name:    "leader unreachable",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true, down: true}, {name: "example-0001", rev: 12}, {name: "example-0002", rev: 11}},
+		policy:  api.MemberSelectionPreferLeader,
+		want:    "example-0001",
+		wantRev: 12,
+	}, {
+		name:    "follower",
+		members: []fakeMember{{name: "example-0000", rev: 10}, {name: "example-0001", rev: 12, isLeader: true}, {name: "example-0002", rev: 11}},
+		policy:  api.MemberSelectionPreferFollower,
+		want:    "example-0002",
+		wantRev: 11,
+	}, {
+		name:    "followers unreachable",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true}, {name: "example-0001", rev: 12, down: true}, {name: "example-0002", rev: 11, down: true}},
+		policy:  api.MemberSelectionPreferFollower,
+		want:    "example-0000",
+		wantRev: 10,
+	}, {
+		name:    "explicit member",
+		members: healthy,
+		policy:  api.MemberSelectionPreferFollower,
+		member:  "example-0000",
+		want:    "example-0000",
+		wantRev: 10,
+	}, {
+		name:    "explicit member missing",
+		members: healthy,
+		member:  "example-0003",
+	}, {
+		name:    "explicit member unreachable",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true}, {name: "example-0001", rev: 12, down: true}, {name: "example-0002", rev: 11}},
+		member:  "example-0001",
+	}, {
+		name:    "all members unreachable",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true, down: true}, {name: "example-0001", rev: 12, down: true}},
+	}, {
+		name:    "all members unreachable, preferring the leader",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true, down: true}, {name: "example-0001", rev: 12, down: true}},
+		policy:  api.MemberSelectionPreferLeader,
+	}, {
+		name:    "all members unreachable, preferring a follower",
+		members: []fakeMember{{name: "example-0000", rev: 10, isLeader: true, down: true}, {name: "example-0001", rev: 12, down: true}},
+		policy:  api.MemberSelectionPreferFollower,
+	}}
+	for _, tt := range tests {
+		pods, restore := fakeStatuses(tt.members)
+		m, rev := selectMember(getMemberStatuses(pods, nil), tt.policy, tt.member)
+		restore()
+		if tt.want == "" {
+			if m != nil {
+				t.Errorf("%s: selected member %s; want none", tt.name, m.Name)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%s: selected no member; want %s", tt.name, tt.want)
+			continue
+		}
+		if m.Name != tt.want || rev != tt.wantRev {
+			t.Errorf("%s: selected member %s at revision %d; want %s at revision %d", tt.name, m.Name, rev, tt.want, tt.wantRev)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionSuffixes maps compressions to the suffixes they add to backup names.
+var compressionSuffixes = map[string]string{
+	api.BackupCompressionGzip: ".gz",
+	api.BackupCompressionZstd: ".zst",
+}
+
+func validateCompression(compression string) error {
+	switch compression {
+	case "", api.BackupCompressionNone, api.BackupCompressionGzip, api.BackupCompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression: %v", compression)
+}
+
+// compressionOf returns the compression of the backup with the given name.
+func compressionOf(name string) string {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	for c, suffix := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return c
+		}
+	}
+	return api.BackupCompressionNone
+}
+
+// snapshotFileName returns the name of the backup with the given name
+// without the suffixes added by its compression and encryption.
+func snapshotFileName(name string) string {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	return strings.TrimSuffix(name, compressionSuffixes[compressionOf(name)])
+}
+
+// compress returns a reader of the content of r compressed with compression.
+// The returned reader must be closed to release the compressing goroutine.
+func compress(r io.Reader, compression string) io.ReadCloser {
+	if _, ok := compressionSuffixes[compression]; !ok {
+		return ioutil.NopCloser(r)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser
+		var err error
+		switch compression {
+		case api.BackupCompressionGzip:
+			w = gzip.NewWriter(pw)
+		case api.BackupCompressionZstd:
+			w, err = zstd.NewWriter(pw)
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// decompress returns a reader of the content of rc decompressed with compression.
+// Closing the returned reader closes rc.
+func decompress(rc io.ReadCloser, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case api.BackupCompressionGzip:
+		zr, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &multiCloser{Reader: zr, closers: []io.Closer{zr, rc}}, nil
+	case api.BackupCompressionZstd:
+		zr, err := zstd.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &multiCloser{Reader: zr, closers: []io.Closer{zstdCloser{zr}, rc}}, nil
+	}
+	return rc, nil
+}
+
+// zstdCloser releases the goroutines of a zstd decoder on Close.
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+func (zc zstdCloser) Close() error {
+	zc.d.Close()
+	return nil
+}
+
+// multiCloser is a reader that closes all of closers in order.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (mc *multiCloser) Close() error {
+	var err error
+	for _, c := range mc.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openBackup returns a reader of the content of the backup with the given name in be,
+// undoing the encryption and compression the backup was saved with.
+// kr holds the keys to decrypt encrypted backups with; it may be nil.
+func openBackup(be Backend, name string, kr Keyring) (io.ReadCloser, error) {
+	rc, err := be.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapBackup(rc, name, kr)
+}
+
+// unwrapBackup returns a reader of rc, the stored content of the backup with the given name,
+// with its encryption and compression undone. Closing the returned reader closes rc.
+func unwrapBackup(rc io.ReadCloser, name string, kr Keyring) (io.ReadCloser, error) {
+	var err error
+	if isEncrypted(name) {
+		if kr == nil {
+			rc.Close()
+			return nil, fmt.Errorf("backup %s is encrypted but no encryption keys are available", name)
+		}
+		rc, err = decrypt(rc, kr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decompress(rc, compressionOf(name))
+}
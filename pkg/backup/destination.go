@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+)
+
+const (
+	// fanoutChunkSize is the size of the chunks a backup is fanned out to destinations in.
+	fanoutChunkSize = 32 * 1024
+	// destinationBufferChunks is the number of chunks buffered for each destination,
+	// so that destinations are written at their own pace.
+	destinationBufferChunks = 64
+)
+
+var (
+	// destinationWriteTimeout is how long a destination may stall with a full buffer before it is dropped.
+	destinationWriteTimeout = time.Minute
+	// destinationSaveTimeout is how long destinations may take to finish saving
+	// once the whole backup was handed to them.
+	destinationSaveTimeout = 30 * time.Minute
+)
+
+var errStoppedReading = errors.New("destination stopped reading the backup early")
+
+// destination is a named backend backups are written to.
+type destination struct {
+	name string
+	be   Backend
+}
+
+// newDestinations creates the backends of all destinations of sp.
+func newDestinations(sp api.EtcdBackupSpec, namespace, clusterName string) ([]destination, error) {
+	if err := sp.ValidateDestinations(); err != nil {
+		return nil, err
+	}
+	var dests []destination
+	for _, d := range sp.GetDestinations() {
+		be, err := NewBackend(sp.ForDestination(d), namespace, clusterName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backend of destination (%s): %v", d.Name, err)
+		}
+		dests = append(dests, destination{name: d.Name, be: be})
+	}
+	return dests, nil
+}
+
+// NewDestinationBackend creates the backend of the destination of sp with the given name.
+// The first destination is used if name is empty.
+func NewDestinationBackend(sp api.EtcdBackupSpec, name, namespace, clusterName string) (Backend, error) {
+	d, ok := sp.GetDestination(name)
+	if !ok {
+		return nil, fmt.Errorf("destination not found: %s", name)
+	}
+	return NewBackend(sp.ForDestination(d), namespace, clusterName)
+}
+
+// saveToAll saves the backup read from r under name, along with meta, to all of dests.
+// r is read once and fanned out to the destinations, which are written in parallel.
+// It returns the size of the backup and the error of each destination.
+//
+// Each destination has its own buffer, so a slow destination doesn't hold back the others.
+// A destination that fails, or stalls for destinationWriteTimeout with a full buffer,
+// is dropped without affecting the others, and isn't waited for: the reader of its Save
+// fails instead, which makes Save return.
+func saveToAll(dests []destination, name string, r io.Reader, meta map[string]string) (int64, []error) {
+	errs := make([]error, len(dests))
+	if len(dests) == 1 {
+		var n int64
+		n, errs[0] = dests[0].be.Save(name, r, meta)
+		return n, errs
+	}
+
+	dws := make([]*destinationWriter, len(dests))
+	for i, d := range dests {
+		dws[i] = newDestinationWriter()
+		go dws[i].save(d.be, name, meta)
+	}
+
+	var n int64
+	var rerr error
+	buf := make([]byte, fanoutChunkSize)
+	for {
+		m, err := r.Read(buf)
+		if m > 0 {
+			// Destinations consume chunks at their own pace, so each chunk needs its own copy.
+			chunk := make([]byte, m)
+			copy(chunk, buf[:m])
+			alive := false
+			for _, dw := range dws {
+				if dw.send(chunk) {
+					alive = true
+				}
+			}
+			if !alive {
+				rerr = errors.New("all destinations failed")
+				break
+			}
+			n += int64(m)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rerr = err
+			break
+		}
+	}
+
+	// All streams are ended before any destination is waited for, so that a destination
+	// that hangs doesn't keep the others from finishing.
+	for _, dw := range dws {
+		dw.end(rerr)
+	}
+	deadline := time.Now().Add(destinationSaveTimeout)
+	for i, dw := range dws {
+		errs[i] = dw.wait(deadline)
+	}
+	return n, errs
+}
+
+// destinationWriter feeds the chunks of a backup to the Save of one destination.
+type destinationWriter struct {
+	chunks chan []byte
+	pr     *io.PipeReader
+	pw     *io.PipeWriter
+	// closeErr is the error the backup stream ended with; nil on EOF.
+	closeErr error
+
+	// done is closed once Save returned with err.
+	done chan struct{}
+	err  error
+
+	// dropped is the reason the destination was dropped; nil while it is written to.
+	dropped error
+}
+
+func newDestinationWriter() *destinationWriter {
+	pr, pw := io.Pipe()
+	dw := &destinationWriter{
+		chunks: make(chan []byte, destinationBufferChunks),
+		pr:     pr,
+		pw:     pw,
+		done:   make(chan struct{}),
+	}
+	go dw.feed()
+	return dw
+}
+
+// feed writes the buffered chunks to the pipe Save reads from.
+func (dw *destinationWriter) feed() {
+	for c := range dw.chunks {
+		if _, err := dw.pw.Write(c); err != nil {
+			// Save stopped reading; the reason is reported by save.
+			return
+		}
+	}
+	dw.pw.CloseWithError(dw.closeErr)
+}
+
+func (dw *destinationWriter) save(be Backend, name string, meta map[string]string) {
+	_, err := be.Save(name, dw.pr, meta)
+	// Unblock the feeder if Save stopped reading early.
+	dw.pr.CloseWithError(errStoppedReading)
+	dw.err = err
+	close(dw.done)
+}
+
+// send buffers chunk for the destination. It returns false if the destination was dropped.
+func (dw *destinationWriter) send(chunk []byte) bool {
+	if dw.dropped != nil {
+		return false
+	}
+	select {
+	case dw.chunks <- chunk:
+		return true
+	default:
+	}
+
+	t := time.NewTimer(destinationWriteTimeout)
+	defer t.Stop()
+	select {
+	case dw.chunks <- chunk:
+		return true
+	case <-dw.done:
+		dw.dropped = dw.err
+		if dw.dropped == nil {
+			dw.dropped = errStoppedReading
+		}
+	case <-t.C:
+		dw.dropped = fmt.Errorf("destination stalled for %v", destinationWriteTimeout)
+		dw.stop(dw.dropped)
+	}
+	return false
+}
+
+// stop makes the reader of Save fail with err, which also unblocks the feeder.
+func (dw *destinationWriter) stop(err error) {
+	dw.pw.CloseWithError(err)
+}
+
+// end ends the backup stream of the destination with err.
+func (dw *destinationWriter) end(err error) {
+	if dw.dropped == nil {
+		// closeErr is read by the feeder only after chunks is closed.
+		dw.closeErr = err
+	}
+	close(dw.chunks)
+}
+
+// wait returns the result of the Save of the destination, or an error if Save doesn't
+// return by deadline. Dropped destinations aren't waited for.
+func (dw *destinationWriter) wait(deadline time.Time) error {
+	if dw.dropped != nil {
+		return dw.dropped
+	}
+	t := time.NewTimer(deadline.Sub(time.Now()))
+	defer t.Stop()
+	select {
+	case <-dw.done:
+		return dw.err
+	case <-t.C:
+	}
+	// The deadline is shared, so it may have passed while other destinations were waited for.
+	select {
+	case <-dw.done:
+		return dw.err
+	default:
+	}
+	err := fmt.Errorf("destination did not finish saving within %v", destinationSaveTimeout)
+	dw.stop(err)
+	return err
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// funcBackend is a Backend that saves backups with save.
+type funcBackend struct {
+	Backend
+	save func(r io.Reader) (int64, error)
+}
+
+func (fb *funcBackend) Save(name string, r io.Reader, meta map[string]string) (int64, error) {
+	return fb.save(r)
+}
+
+// blockingSave returns a save that reads n bytes, then waits for release before reading the rest.
+// The result of the save is sent on the returned channel.
+func blockingSave(n int64, release <-chan struct{}) (func(r io.Reader) (int64, error), <-chan error) {
+	done := make(chan error, 1)
+	return func(r io.Reader) (int64, error) {
+		m, err := io.CopyN(ioutil.Discard, r, n)
+		if err == nil {
+			<-release
+			var k int64
+			k, err = io.Copy(ioutil.Discard, r)
+			m += k
+		}
+		done <- err
+		return m, err
+	}, done
+}
+
+// waitSave returns the result of a save started with blockingSave.
+func waitSave(t *testing.T, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Save didn't return")
+		return nil
+	}
+}
+
+func checkSaved(t *testing.T, be *memBackend, name string, want []byte) {
+	o := be.object(name)
+	if o == nil {
+		t.Fatalf("backup %s wasn't saved", name)
+	}
+	if !bytes.Equal(o.data, want) {
+		t.Errorf("saved %d bytes that differ from the %d bytes of the backup", len(o.data), len(want))
+	}
+}
+
+func TestSaveToAllSlowDestination(t *testing.T) {
+	// The backup fits in the buffer of the slow destination.
+	data := testPlaintext(destinationBufferChunks * fanoutChunkSize / 2)
+	fast := newMemBackend()
+	release := make(chan struct{})
+	save, slowDone := blockingSave(fanoutChunkSize, release)
+	dests := []destination{{name: "fast", be: fast}, {name: "slow", be: &funcBackend{save: save}}}
+
+	type result struct {
+		n    int64
+		errs []error
+	}
+	res := make(chan result)
+	go func() {
+		n, errs := saveToAll(dests, "backup", bytes.NewReader(data), nil)
+		res <- result{n, errs}
+	}()
+
+	// The fast destination is written to the end while the slow one waits.
+	deadline := time.Now().Add(10 * time.Second)
+	for fast.object("backup") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the slow destination held back the fast one")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-res:
+		t.Fatal("saveToAll returned before the slow destination finished")
+	default:
+	}
+
+	close(release)
+	r := <-res
+	if r.n != int64(len(data)) {
+		t.Errorf("saved %d bytes, want %d", r.n, len(data))
+	}
+	for i, err := range r.errs {
+		if err != nil {
+			t.Errorf("destination %s failed: %v", dests[i].name, err)
+		}
+	}
+	if err := waitSave(t, slowDone); err != nil {
+		t.Errorf("slow destination failed: %v", err)
+	}
+	checkSaved(t, fast, "backup", data)
+}
+
+func TestSaveToAllFailingDestination(t *testing.T) {
+	data := testPlaintext(4 << 20)
+	errUpload := errors.New("upload failed")
+	failing := &funcBackend{save: func(r io.Reader) (int64, error) {
+		io.CopyN(ioutil.Discard, r, 100*1024)
+		return -1, errUpload
+	}}
+	be1, be2 := newMemBackend(), newMemBackend()
+	dests := []destination{{name: "a", be: be1}, {name: "failing", be: failing}, {name: "b", be: be2}}
+
+	n, errs := saveToAll(dests, "backup", bytes.NewReader(data), nil)
+	if n != int64(len(data)) {
+		t.Errorf("saved %d bytes, want %d", n, len(data))
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("healthy destinations failed: %v, %v", errs[0], errs[2])
+	}
+	if errs[1] != errUpload {
+		t.Errorf("failing destination returned %v, want %v", errs[1], errUpload)
+	}
+	checkSaved(t, be1, "backup", data)
+	checkSaved(t, be2, "backup", data)
+}
+
+func TestSaveToAllDestinationsFail(t *testing.T) {
+	errUpload := errors.New("upload failed")
+	failing := &funcBackend{save: func(r io.Reader) (int64, error) { return -1, errUpload }}
+	dests := []destination{{name: "a", be: failing}, {name: "b", be: failing}}
+
+	_, errs := saveToAll(dests, "backup", bytes.NewReader(testPlaintext(4<<20)), nil)
+	for i, err := range errs {
+		if err != errUpload {
+			t.Errorf("destination %s returned %v, want %v", dests[i].name, err, errUpload)
+		}
+	}
+}
+
+func TestSaveToAllStalledDestination(t *testing.T) {
+	defer func(d time.Duration) { destinationWriteTimeout = d }(destinationWriteTimeout)
+	destinationWriteTimeout = 50 * time.Millisecond
+
+	// The backup overflows the buffer of the stalled destination.
+	data := testPlaintext(4 << 20)
+	release := make(chan struct{})
+	save, stalledDone := blockingSave(fanoutChunkSize, release)
+	be := newMemBackend()
+	dests := []destination{{name: "stalled", be: &funcBackend{save: save}}, {name: "healthy", be: be}}
+
+	n, errs := saveToAll(dests, "backup", bytes.NewReader(data), nil)
+	if n != int64(len(data)) {
+		t.Errorf("saved %d bytes, want %d", n, len(data))
+	}
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), "stalled") {
+		t.Errorf("stalled destination returned %v, want a stall error", errs[0])
+	}
+	if errs[1] != nil {
+		t.Errorf("healthy destination failed: %v", errs[1])
+	}
+	checkSaved(t, be, "backup", data)
+
+	// Once the stalled Save reads again, its reader fails and it returns.
+	close(release)
+	if err := waitSave(t, stalledDone); err == nil || err.Error() != errs[0].Error() {
+		t.Errorf("stalled Save read error %v, want %v", err, errs[0])
+	}
+}
+
+func TestSaveToAllSaveTimeout(t *testing.T) {
+	defer func(d time.Duration) { destinationSaveTimeout = d }(destinationSaveTimeout)
+	destinationSaveTimeout = 50 * time.Millisecond
+
+	data := testPlaintext(1 << 20)
+	release := make(chan struct{})
+	// The destination reads all but the last byte, then hangs.
+	save, hungDone := blockingSave(int64(len(data)-1), release)
+	be := newMemBackend()
+	dests := []destination{{name: "hung", be: &funcBackend{save: save}}, {name: "healthy", be: be}}
+
+	_, errs := saveToAll(dests, "backup", bytes.NewReader(data), nil)
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), "did not finish saving") {
+		t.Errorf("hung destination returned %v, want a timeout", errs[0])
+	}
+	if errs[1] != nil {
+		t.Errorf("healthy destination failed: %v", errs[1])
+	}
+
+	close(release)
+	if err := waitSave(t, hungDone); err == nil || err.Error() != errs[0].Error() {
+		t.Errorf("hung Save read error %v, want %v", err, errs[0])
+	}
+}
@@ -0,0 +1,329 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+)
+
+// Encrypted backups are stored in an envelope:
+//
+//	magic | key ID length (uint16) | key ID | wrapped data key length (uint16) | wrapped data key | chunks
+//
+// The data key is a random AES-256 key generated for each backup, wrapped with
+// AES-GCM by the key named key ID. The key ID is stored in plaintext, so the
+// backup can be decrypted with the matching key after the keys are rotated.
+//
+// The snapshot is sealed with the data key in chunks of at most encryptionChunkSize bytes:
+//
+//	flags and ciphertext length (uint32) | ciphertext
+//
+// The high bit of the length marks the last chunk. It is authenticated along with the
+// ciphertext, and each chunk's nonce is its sequence number, so chunks can't be
+// dropped, reordered or truncated without failing decryption. Data after the last chunk
+// fails decryption too.
+const (
+	encryptedSuffix     = ".enc"
+	encryptionMagic     = "ETCDBAK1"
+	encryptionKeySize   = 32
+	encryptionChunkSize = 64 * 1024
+
+	lastChunkFlag = 1 << 31
+
+	// encryptionKeyIDMetadataKey is the object metadata key the key ID of an
+	// encrypted backup is stored under, so it can be found without reading the backup.
+	// Azure only allows metadata names that are valid C# identifiers.
+	encryptionKeyIDMetadataKey = "encryptionkeyid"
+)
+
+var (
+	errTruncated    = errors.New("encrypted backup is truncated")
+	errTrailingData = errors.New("encrypted backup has data after its last chunk")
+)
+
+// Keyring holds the keys that data keys are wrapped with, by key ID.
+type Keyring map[string][]byte
+
+// LoadKeyring loads the keys in dir, which is the mount point of a Secret
+// whose data maps key IDs to 32 byte AES keys.
+func LoadKeyring(dir string) (Keyring, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	kr := Keyring{}
+	for _, fi := range fis {
+		// Secret volumes keep their data in hidden directories linked from the visible files.
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		key, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("encryption key %s is %d bytes; expect %d", fi.Name(), len(key), encryptionKeySize)
+		}
+		kr[fi.Name()] = key
+	}
+	return kr, nil
+}
+
+// newEncryptionKeyring loads the keyring of the encryption policy ep and checks that
+// it holds the key backups are encrypted with. It returns nil if ep is nil.
+func newEncryptionKeyring(ep *api.EncryptionPolicy, dir string) (Keyring, error) {
+	if ep == nil {
+		return nil, nil
+	}
+	kr, err := LoadKeyring(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+	}
+	if _, ok := kr[ep.KeyID]; !ok {
+		return nil, fmt.Errorf("encryption key %s not found in secret %s", ep.KeyID, ep.KeySecret)
+	}
+	return kr, nil
+}
+
+func isEncrypted(name string) bool {
+	return strings.HasSuffix(name, encryptedSuffix)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns a reader of the content of r encrypted with a new data key
+// wrapped by the key named keyID in kr.
+// The returned reader must be closed to release the encrypting goroutine.
+func encrypt(r io.Reader, kr Keyring, keyID string) (io.ReadCloser, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	header, err := makeEnvelopeHeader(kr, keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(sealChunks(pw, r, header, aead))
+	}()
+	return pr, nil
+}
+
+func makeEnvelopeHeader(kr Keyring, keyID string, dataKey []byte) ([]byte, error) {
+	key, ok := kr[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %s not found", keyID)
+	}
+	kek, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, kek.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped := kek.Seal(nonce, nonce, dataKey, []byte(keyID))
+
+	var buf bytes.Buffer
+	buf.WriteString(encryptionMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(len(keyID)))
+	buf.WriteString(keyID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(wrapped)))
+	buf.Write(wrapped)
+	return buf.Bytes(), nil
+}
+
+func sealChunks(w io.Writer, r io.Reader, header []byte, aead cipher.AEAD) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	buf := make([]byte, encryptionChunkSize)
+	var seq uint64
+	for {
+		n, err := io.ReadFull(r, buf)
+		var flags uint32
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			flags = lastChunkFlag
+		default:
+			return err
+		}
+		ct := aead.Seal(nil, chunkNonce(aead, seq), buf[:n], chunkAAD(flags))
+		if err = binary.Write(w, binary.BigEndian, flags|uint32(len(ct))); err != nil {
+			return err
+		}
+		if _, err = w.Write(ct); err != nil {
+			return err
+		}
+		if flags&lastChunkFlag != 0 {
+			return nil
+		}
+		seq++
+	}
+}
+
+func chunkNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+func chunkAAD(flags uint32) []byte {
+	aad := make([]byte, 4)
+	binary.BigEndian.PutUint32(aad, flags)
+	return aad
+}
+
+// decrypt returns a reader of the content of rc decrypted with the key in kr
+// named in its envelope. Closing the returned reader closes rc.
+func decrypt(rc io.ReadCloser, kr Keyring) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	dataKey, err := readEnvelopeHeader(br, kr)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &decryptReader{r: br, c: rc, aead: aead}, nil
+}
+
+func readEnvelopeHeader(r io.Reader, kr Keyring) ([]byte, error) {
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("failed to read encryption envelope: %v", err)
+	}
+	if string(magic) != encryptionMagic {
+		return nil, errors.New("not an encrypted backup")
+	}
+	keyID, err := readLengthPrefixed(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption envelope: %v", err)
+	}
+	wrapped, err := readLengthPrefixed(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption envelope: %v", err)
+	}
+
+	key, ok := kr[string(keyID)]
+	if !ok {
+		return nil, fmt.Errorf("backup is encrypted with unknown key %s", keyID)
+	}
+	kek, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < kek.NonceSize() {
+		return nil, errors.New("invalid wrapped data key")
+	}
+	dataKey, err := kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %s: %v", keyID, err)
+	}
+	return dataKey, nil
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// decryptReader reads the chunks of an envelope and returns their plaintext.
+type decryptReader struct {
+	r    io.Reader
+	c    io.Closer
+	aead cipher.AEAD
+
+	seq  uint64
+	buf  []byte
+	done bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) openChunk() error {
+	var hdr uint32
+	if err := binary.Read(dr.r, binary.BigEndian, &hdr); err != nil {
+		if err == io.EOF {
+			return errTruncated
+		}
+		return err
+	}
+	flags, n := hdr&lastChunkFlag, hdr&^lastChunkFlag
+	if n > encryptionChunkSize+uint32(dr.aead.Overhead()) {
+		return fmt.Errorf("invalid encrypted chunk size %d", n)
+	}
+	ct := make([]byte, n)
+	if _, err := io.ReadFull(dr.r, ct); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	pt, err := dr.aead.Open(ct[:0], chunkNonce(dr.aead, dr.seq), ct, chunkAAD(flags))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %v", dr.seq, err)
+	}
+	if flags != 0 {
+		// Nothing may follow the last chunk.
+		var b [1]byte
+		if _, err = io.ReadFull(dr.r, b[:]); err != io.EOF {
+			if err == nil {
+				return errTrailingData
+			}
+			return err
+		}
+	}
+	dr.buf = pt
+	dr.seq++
+	dr.done = flags != 0
+	return nil
+}
+
+func (dr *decryptReader) Close() error {
+	return dr.c.Close()
+}
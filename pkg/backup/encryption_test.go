@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testKeyring() Keyring {
+	return Keyring{
+		"key-1": bytes.Repeat([]byte{1}, encryptionKeySize),
+		"key-2": bytes.Repeat([]byte{2}, encryptionKeySize),
+	}
+}
+
+func testPlaintext(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func encryptBytes(t *testing.T, data []byte, kr Keyring, keyID string) []byte {
+	rc, err := encrypt(bytes.NewReader(data), kr, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	enc, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func decryptBytes(enc []byte, kr Keyring) ([]byte, error) {
+	rc, err := decrypt(ioutil.NopCloser(bytes.NewReader(enc)), kr)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// splitEnvelope returns the header of the envelope enc, and its chunks with their length prefixes.
+func splitEnvelope(t *testing.T, enc []byte) ([]byte, [][]byte) {
+	off := len(encryptionMagic)
+	for i := 0; i < 2; i++ {
+		off += 2 + int(binary.BigEndian.Uint16(enc[off:]))
+	}
+	header, rest := enc[:off], enc[off:]
+	var chunks [][]byte
+	for len(rest) != 0 {
+		if len(rest) < 4 {
+			t.Fatalf("chunk header is cut: %d bytes left", len(rest))
+		}
+		n := 4 + int(binary.BigEndian.Uint32(rest)&^lastChunkFlag)
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	return header, chunks
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantChunks int
+	}{
+		{name: "empty", size: 0, wantChunks: 1},
+		{name: "one chunk", size: 1000, wantChunks: 1},
+		// A full chunk is followed by an empty last chunk.
+		{name: "exactly one full chunk", size: encryptionChunkSize, wantChunks: 2},
+		{name: "multiple chunks", size: 3*encryptionChunkSize + 17, wantChunks: 4},
+	}
+	for _, tt := range tests {
+		data := testPlaintext(tt.size)
+		enc := encryptBytes(t, data, testKeyring(), "key-2")
+		if _, chunks := splitEnvelope(t, enc); len(chunks) != tt.wantChunks {
+			t.Errorf("%s: got %d chunks; want %d", tt.name, len(chunks), tt.wantChunks)
+		}
+		got, err := decryptBytes(enc, testKeyring())
+		if err != nil {
+			t.Errorf("%s: decrypt failed: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: decrypted %d bytes that don't match the %d bytes encrypted", tt.name, len(got), len(data))
+		}
+	}
+}
+
+func TestDecryptFails(t *testing.T) {
+	enc := encryptBytes(t, testPlaintext(3*encryptionChunkSize+17), testKeyring(), "key-1")
+	header, chunks := splitEnvelope(t, enc)
+	// copyChunk returns a copy of chunk i that is safe to modify.
+	copyChunk := func(i int) []byte {
+		return append([]byte(nil), chunks[i]...)
+	}
+
+	tests := []struct {
+		name    string
+		enc     func() []byte
+		kr      Keyring
+		wantErr string
+	}{{
+		name:    "cut at a chunk boundary",
+		enc:     func() []byte { return join(header, chunks[0], chunks[1]) },
+		wantErr: errTruncated.Error(),
+	}, {
+		name:    "cut inside a chunk",
+		enc:     func() []byte { return enc[:len(enc)-10] },
+		wantErr: errTruncated.Error(),
+	}, {
+		name: "last chunk bit cleared",
+		enc: func() []byte {
+			last := copyChunk(3)
+			last[0] &^= 0x80
+			return join(header, chunks[0], chunks[1], chunks[2], last)
+		},
+		wantErr: "failed to decrypt chunk 3",
+	}, {
+		name: "last chunk bit set early",
+		enc: func() []byte {
+			first := copyChunk(0)
+			first[0] |= 0x80
+			return join(header, first)
+		},
+		wantErr: "failed to decrypt chunk 0",
+	}, {
+		name:    "chunks swapped",
+		enc:     func() []byte { return join(header, chunks[1], chunks[0], chunks[2], chunks[3]) },
+		wantErr: "failed to decrypt chunk 0",
+	}, {
+		name:    "chunk dropped",
+		enc:     func() []byte { return join(header, chunks[0], chunks[2], chunks[3]) },
+		wantErr: "failed to decrypt chunk 1",
+	}, {
+		name: "ciphertext tampered",
+		enc: func() []byte {
+			c := copyChunk(1)
+			c[100] ^= 1
+			return join(header, chunks[0], c, chunks[2], chunks[3])
+		},
+		wantErr: "failed to decrypt chunk 1",
+	}, {
+		name: "wrapped data key tampered",
+		enc: func() []byte {
+			h := append([]byte(nil), header...)
+			h[len(h)-1] ^= 1
+			return join(h, chunks[0], chunks[1], chunks[2], chunks[3])
+		},
+		wantErr: "failed to unwrap data key with key key-1",
+	}, {
+		name:    "unknown key ID",
+		enc:     func() []byte { return enc },
+		kr:      Keyring{"key-2": testKeyring()["key-2"]},
+		wantErr: "encrypted with unknown key key-1",
+	}, {
+		name:    "wrong key for key ID",
+		enc:     func() []byte { return enc },
+		kr:      Keyring{"key-1": testKeyring()["key-2"]},
+		wantErr: "failed to unwrap data key with key key-1",
+	}, {
+		name:    "data appended",
+		enc:     func() []byte { return join(enc, []byte("x")) },
+		wantErr: errTrailingData.Error(),
+	}, {
+		name:    "chunk appended",
+		enc:     func() []byte { return join(enc, chunks[3]) },
+		wantErr: errTrailingData.Error(),
+	}, {
+		name:    "not encrypted",
+		enc:     func() []byte { return []byte("etcd snapshot") },
+		wantErr: "not an encrypted backup",
+	}}
+	for _, tt := range tests {
+		kr := tt.kr
+		if kr == nil {
+			kr = testKeyring()
+		}
+		_, err := decryptBytes(tt.enc(), kr)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v; want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEncryptUnknownKey(t *testing.T) {
+	if _, err := encrypt(bytes.NewReader(nil), testKeyring(), "key-3"); err == nil {
+		t.Error("encrypted with a key that isn't in the keyring")
+	}
+}
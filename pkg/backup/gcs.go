@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/backup/gcs"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+)
+
+const (
+	GCSV1 = "v1"
+)
+
+func init() {
+	RegisterBackend(api.BackupStorageTypeGCS, newGCSBackend)
+}
+
+func ToGCSPrefix(gcsPrefix, namespace, clusterName string) string {
+	return path.Join(gcsPrefix, GCSV1, namespace, clusterName)
+}
+
+type gcsBackend struct {
+	GCS *gcs.GCS
+}
+
+func newGCSBackend(sp api.EtcdBackupSpec, namespace, clusterName, tmpDir string) (Backend, error) {
+	if sp.GCS == nil {
+		return nil, errors.New("gcs storage source is not set")
+	}
+	var credentialsFile string
+	if len(sp.GCS.GCPSecret) != 0 {
+		credentialsFile = filepath.Join(constants.GCSCredentialDir, constants.GCSCredentialFile)
+	}
+	gcscli, err := gcs.New(sp.GCS.Bucket, ToGCSPrefix(sp.GCS.Prefix, namespace, clusterName), credentialsFile, sp.GCS.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBackend{GCS: gcscli}, nil
+}
+
+func (gb *gcsBackend) Save(key string, r io.Reader, meta map[string]string) (int64, error) {
+	// GCS uploads are resumable and only become visible once complete, so the snapshot is streamed directly.
+	return gb.GCS.Put(key, r, meta)
+}
+
+func (gb *gcsBackend) List() ([]string, error) {
+	return gb.GCS.List()
+}
+
+func (gb *gcsBackend) Get(key string) (io.ReadCloser, error) {
+	rc, err := gb.GCS.Get(key)
+	if gcs.IsNotFound(err) {
+		return nil, &notFoundError{name: key}
+	}
+	return rc, err
+}
+
+func (gb *gcsBackend) Delete(key string) error {
+	return gb.GCS.Delete(key)
+}
+
+func (gb *gcsBackend) TotalSize() (int64, error) {
+	return gb.GCS.TotalSize()
+}
+
+func (gb *gcsBackend) LastModified(key string) (time.Time, error) {
+	return gb.GCS.LastModified(key)
+}
@@ -0,0 +1,186 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// gcsHosts are the hosts the storage client sends requests to.
+// The JSON API is served from www.googleapis.com, and objects are read from storage.googleapis.com.
+var gcsHosts = map[string]bool{
+	"www.googleapis.com":     true,
+	"storage.googleapis.com": true,
+}
+
+// GCS is a helper layer to wrap complex GCS logic.
+type GCS struct {
+	bucket string
+	prefix string
+	client *storage.Client
+}
+
+// New returns a GCS translator that authenticates with the service account key in credentialsFile.
+// If credentialsFile is empty, the application default credentials are used.
+//
+// If endpoint is set, requests are sent to the GCS compatible server at that URL instead,
+// such as an emulator. Requests to it are only authenticated if credentialsFile is set.
+func New(bucket, prefix, credentialsFile, endpoint string) (*GCS, error) {
+	ctx := context.Background()
+	var opts []option.ClientOption
+	if len(credentialsFile) != 0 {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	if len(endpoint) != 0 {
+		hc, err := newEndpointClient(ctx, endpoint, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithHTTPClient(hc)}
+	}
+	cli, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("new GCS client failed: %v", err)
+	}
+
+	return NewFromClient(bucket, prefix, cli), nil
+}
+
+// newEndpointClient returns an HTTP client that sends requests for GCS to endpoint.
+// The storage client reads objects from a fixed host whatever its endpoint option is,
+// so the hosts are rewritten on every request instead.
+func newEndpointClient(ctx context.Context, endpoint string, opts []option.ClientOption) (*http.Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS endpoint (%s): %v", endpoint, err)
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid GCS endpoint (%s): scheme and host are required", endpoint)
+	}
+	rt := http.DefaultTransport
+	if len(opts) != 0 {
+		hc, _, err := htransport.NewClient(ctx, append(opts, option.WithScopes(storage.ScopeFullControl))...)
+		if err != nil {
+			return nil, fmt.Errorf("new GCS client failed: %v", err)
+		}
+		rt = hc.Transport
+	}
+	return &http.Client{Transport: &endpointTransport{endpoint: u, rt: rt}}, nil
+}
+
+// endpointTransport sends requests for GCS to endpoint.
+type endpointTransport struct {
+	endpoint *url.URL
+	rt       http.RoundTripper
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !gcsHosts[req.URL.Host] {
+		return t.rt.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Scheme = t.endpoint.Scheme
+	u.Host = t.endpoint.Host
+	r.URL = &u
+	r.Host = ""
+	return t.rt.RoundTrip(r)
+}
+
+func NewFromClient(bucket, prefix string, cli *storage.Client) *GCS {
+	return &GCS{
+		bucket: bucket,
+		prefix: prefix,
+		client: cli,
+	}
+}
+
+func (g *GCS) object(key string) *storage.ObjectHandle {
+	return g.client.Bucket(g.bucket).Object(path.Join(g.prefix, key))
+}
+
+// Put uploads the content of r. The object is created only if r is read to the end.
+func (g *GCS) Put(key string, r io.Reader, meta map[string]string) (int64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := g.object(key).NewWriter(ctx)
+	w.Metadata = meta
+	n, err := io.Copy(w, r)
+	if err != nil {
+		// Canceling the context aborts the upload.
+		cancel()
+		w.Close()
+		return -1, err
+	}
+	if err = w.Close(); err != nil {
+		return -1, err
+	}
+	return n, nil
+}
+
+func (g *GCS) Get(key string) (io.ReadCloser, error) {
+	return g.object(key).NewReader(context.Background())
+}
+
+// IsNotFound tells whether err is the error Get returns for keys that don't exist.
+func IsNotFound(err error) bool {
+	return err == storage.ErrObjectNotExist
+}
+
+func (g *GCS) LastModified(key string) (time.Time, error) {
+	attrs, err := g.object(key).Attrs(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return attrs.Updated, nil
+}
+
+func (g *GCS) Delete(key string) error {
+	return g.object(key).Delete(context.Background())
+}
+
+func (g *GCS) List() ([]string, error) {
+	_, l, err := g.list(g.prefix)
+	return l, err
+}
+
+// list returns the total size and the keys of the objects under prefix.
+func (g *GCS) list(prefix string) (int64, []string, error) {
+	// GCS doesn't have dir. It only recognizes prefix.
+	dir := prefix + "/"
+	keys := []string{}
+	var size int64
+	it := g.client.Bucket(g.bucket).Objects(context.Background(), &storage.Query{Prefix: dir})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return -1, nil, err
+		}
+		keys = append(keys, strings.TrimPrefix(attrs.Name, dir))
+		size += attrs.Size
+	}
+
+	return size, keys, nil
+}
+
+func (g *GCS) TotalSize() (int64, error) {
+	size, _, err := g.list(g.prefix)
+	return size, err
+}
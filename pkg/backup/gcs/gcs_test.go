@@ -0,0 +1,436 @@
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket = "etcd-backups"
+	testPrefix = "v1/default/example"
+
+	// maxResults is the most objects GCS returns in one page of a listing.
+	maxResults = 1000
+)
+
+type gcsObject struct {
+	data     []byte
+	metadata map[string]string
+	updated  time.Time
+}
+
+// gcsServer stands in for GCS. It serves the JSON API that the storage client lists,
+// uploads, stats and deletes objects of testBucket with, and the XML API it downloads them from.
+//
+// If key is set, the server also plays the OAuth2 token endpoint of a service account:
+// it grants an access token to JWTs signed with key, and rejects storage requests
+// without that token.
+type gcsServer struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PublicKey
+
+	mu      sync.Mutex
+	objects map[string]*gcsObject
+	// pages counts the pages of listings served.
+	pages int
+	// tokens counts the access tokens granted.
+	tokens int
+}
+
+const accessToken = "ya29.test-token"
+
+func newGCSServer(t *testing.T, key *rsa.PublicKey) *gcsServer {
+	s := &gcsServer{t: t, key: key, objects: map[string]*gcsObject{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/storage/v1/b/"+testBucket+"/o", s.authorized(s.serveList))
+	mux.HandleFunc("/storage/v1/b/"+testBucket+"/o/", s.authorized(s.serveObject))
+	mux.HandleFunc("/upload/storage/v1/b/"+testBucket+"/o", s.authorized(s.serveUpload))
+	mux.HandleFunc("/"+testBucket+"/", s.authorized(s.serveMedia))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// put stores an object as if it was uploaded.
+func (s *gcsServer) put(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = &gcsObject{data: data, updated: time.Now().UTC()}
+}
+
+func (s *gcsServer) object(name string) *gcsObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[name]
+}
+
+// counts returns the number of pages listed and of access tokens granted.
+func (s *gcsServer) counts() (pages, tokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages, s.tokens
+}
+
+// tokenURL is the token endpoint of the service account.
+func (s *gcsServer) tokenURL() string {
+	return s.URL + "/token"
+}
+
+// authorized checks the credentials of a storage request before handing it to h.
+func (s *gcsServer) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch {
+		case s.key == nil && len(auth) != 0:
+			s.t.Errorf("%s %s: unexpected credentials %q", r.Method, r.URL, auth)
+		case s.key != nil && auth != "Bearer "+accessToken:
+			s.t.Errorf("%s %s: credentials %q, want the granted access token", r.Method, r.URL, auth)
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// serveToken grants an access token for a JWT bearer assertion signed by the service account.
+func (s *gcsServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if s.key == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if gt := r.FormValue("grant_type"); gt != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		http.Error(w, "unsupported grant type "+gt, http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(r.FormValue("assertion"), ".")
+	if len(parts) != 3 {
+		http.Error(w, "malformed assertion", http.StatusBadRequest)
+		return
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(s.key, crypto.SHA256, h[:], sig); err != nil {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var claims struct {
+		Aud   string `json:"aud"`
+		Scope string `json:"scope"`
+	}
+	if err = json.Unmarshal(b, &claims); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if claims.Aud != s.tokenURL() || !strings.Contains(claims.Scope, "devstorage.full_control") {
+		http.Error(w, fmt.Sprintf("unexpected claims %+v", claims), http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	s.tokens++
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, accessToken)
+}
+
+// serveList lists the objects whose names start with the prefix parameter, in name order.
+// A page token holds the name of the last object of the previous page.
+func (s *gcsServer) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after := ""
+	if tok := q.Get("pageToken"); len(tok) != 0 {
+		b, err := base64.URLEncoding.DecodeString(tok)
+		if err != nil {
+			http.Error(w, "invalid page token", http.StatusBadRequest)
+			return
+		}
+		after = string(b)
+	}
+	limit := maxResults
+	if mr, err := strconv.Atoi(q.Get("maxResults")); err == nil && mr < limit {
+		limit = mr
+	}
+
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, q.Get("prefix")) && name > after {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resp := map[string]interface{}{"kind": "storage#objects"}
+	if len(names) > limit {
+		names = names[:limit]
+		resp["nextPageToken"] = base64.URLEncoding.EncodeToString([]byte(names[limit-1]))
+	}
+	var items []interface{}
+	for _, name := range names {
+		items = append(items, s.resource(name))
+	}
+	resp["items"] = items
+	s.pages++
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveObject serves the metadata of an object, and deletes objects.
+func (s *gcsServer) serveObject(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"+testBucket+"/o/")
+	if _, ok := s.objects[name]; !ok {
+		http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(s.resource(name))
+	case http.MethodDelete:
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveUpload stores an object sent in a multipart upload: its resource, then its data.
+func (s *gcsServer) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if ut := r.URL.Query().Get("uploadType"); ut != "multipart" {
+		s.t.Errorf("upload type %q, want multipart", ut)
+		http.Error(w, "unsupported upload type", http.StatusBadRequest)
+		return
+	}
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/related" {
+		http.Error(w, "invalid content type", http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var res struct {
+		Name     string            `json:"name"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&res)
+	}
+	if err == nil {
+		part, err = mr.NextPart()
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.objects[res.Name] = &gcsObject{data: data, metadata: res.Metadata, updated: time.Now().UTC()}
+	json.NewEncoder(w).Encode(s.resource(res.Name))
+}
+
+// serveMedia serves the data of an object through the XML API.
+func (s *gcsServer) serveMedia(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")]
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	w.Write(o.data)
+}
+
+func (s *gcsServer) resource(name string) map[string]interface{} {
+	o := s.objects[name]
+	return map[string]interface{}{
+		"kind":     "storage#object",
+		"bucket":   testBucket,
+		"name":     name,
+		"size":     strconv.Itoa(len(o.data)),
+		"metadata": o.metadata,
+		"updated":  o.updated.Format(time.RFC3339Nano),
+	}
+}
+
+// writeServiceAccount writes the key file of a service account that gets its tokens from s,
+// and returns its path.
+func writeServiceAccount(t *testing.T, dir string, s *gcsServer, key *rsa.PrivateKey) string {
+	b, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "example",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"client_email":   "etcd-backup@example.iam.gserviceaccount.com",
+		"token_uri":      s.tokenURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "key.json")
+	if err = ioutil.WriteFile(p, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGCSListPages(t *testing.T) {
+	s := newGCSServer(t, nil)
+	defer s.Close()
+	n := 2*maxResults + 1
+	for rev := 1; rev <= n; rev++ {
+		s.put(fmt.Sprintf("%s/3.2.0_%016x_etcd.backup", testPrefix, rev), make([]byte, rev))
+	}
+	// A cluster restored under another name keeps its backups next to the original's.
+	s.put(testPrefix+"-restored/3.2.0_0000000000000001_etcd.backup", []byte("x"))
+
+	g, err := New(testBucket, testPrefix, "", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := g.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages, _ := s.counts(); pages != 3 {
+		t.Errorf("listed %d pages, want 3", pages)
+	}
+	if len(keys) != n {
+		t.Fatalf("listed %d keys, want %d", len(keys), n)
+	}
+	if want := fmt.Sprintf("3.2.0_%016x_etcd.backup", n); keys[n-1] != want {
+		t.Errorf("last key is %s, want %s", keys[n-1], want)
+	}
+
+	size, err := g.TotalSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(n * (n + 1) / 2); size != want {
+		t.Errorf("total size is %d, want %d", size, want)
+	}
+}
+
+func TestGCSObjects(t *testing.T) {
+	s := newGCSServer(t, nil)
+	defer s.Close()
+	g, err := New(testBucket, testPrefix, "", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "3.2.0_0000000000000007_etcd.backup.aes256gcm"
+	data := "encrypted snapshot"
+	n, err := g.Put(key, strings.NewReader(data), map[string]string{"encryptionkeyid": "key-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("put %d bytes, want %d", n, len(data))
+	}
+	o := s.object(testPrefix + "/" + key)
+	if o == nil {
+		t.Fatalf("object %s/%s wasn't uploaded", testPrefix, key)
+	}
+	if got := o.metadata["encryptionkeyid"]; got != "key-2" {
+		t.Errorf("metadata encryptionkeyid is %q, want key-2", got)
+	}
+
+	rc, err := g.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Errorf("got %q, want %q", b, data)
+	}
+	lm, err := g.LastModified(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lm.Equal(o.updated) {
+		t.Errorf("last modified at %v, want %v", lm, o.updated)
+	}
+
+	if err = g.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Get(key); !IsNotFound(err) {
+		t.Errorf("Get of a deleted object returned %v, want a not found error", err)
+	}
+}
+
+func TestGCSAuthenticatedEndpoint(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newGCSServer(t, &key.PublicKey)
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "gcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := New(testBucket, testPrefix, writeServiceAccount(t, dir, s, key), s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key1 := "3.2.0_0000000000000001_etcd.backup"
+	if _, err = g.Put(key1, strings.NewReader("snapshot"), nil); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := g.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != key1 {
+		t.Errorf("listed %v, want [%s]", keys, key1)
+	}
+	rc, err := g.Get(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if _, tokens := s.counts(); tokens != 1 {
+		t.Errorf("granted %d access tokens, want 1", tokens)
+	}
+}
+
+func TestGCSInvalidEndpoint(t *testing.T) {
+	if _, err := New(testBucket, testPrefix, "", "localhost:4443"); err == nil {
+		t.Error("expected an endpoint without a scheme to be rejected")
+	}
+}
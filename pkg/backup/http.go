@@ -0,0 +1,274 @@
+package backup
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// All paths but HTTPPathMetrics require the bearer token of the sidecar in the Authorization header.
+// It is passed to the sidecar in the environment variable constants.EnvBackupAPIToken.
+const (
+	// HTTPPathBackupNow takes a backup right away on POST.
+	HTTPPathBackupNow = "/v1/backup/now"
+	// HTTPPathStatus reports the result of the last backup attempt on GET.
+	HTTPPathStatus = "/v1/backup/status"
+	// HTTPPathBackups lists the existing backups on GET.
+	// Backups are listed, downloaded and verified from the destination named by the
+	// "destination" query parameter, which defaults to the first destination.
+	HTTPPathBackups = "/v1/backups"
+	// HTTPPathDownload streams a backup on GET.
+	// The backup is selected by the "name" or "revision" query parameter; the latest backup is streamed by default.
+	HTTPPathDownload = "/v1/backup/download"
+	// HTTPPathVerify verifies a backup against its manifest on GET and returns the manifest.
+	// The backup is selected like for HTTPPathDownload. It responds with 404 if the backup has no manifest.
+	HTTPPathVerify = "/v1/backup/verify"
+
+	// HTTPPathMetrics exposes Prometheus metrics.
+	HTTPPathMetrics = "/metrics"
+
+	// HTTPHeaderBackupName is the response header that holds the name of the downloaded backup.
+	HTTPHeaderBackupName = "X-Etcd-Backup-Name"
+
+	bearerPrefix = "Bearer "
+)
+
+// backupResult is the result of a backup attempt.
+type backupResult struct {
+	api.BackupRecord `json:",inline"`
+	// Skipped is true if no backup was saved because nothing changed since the last one.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+type statusResponse struct {
+	LastAttempt       *backupResult `json:"lastAttempt,omitempty"`
+	NextScheduledTime time.Time     `json:"nextScheduledTime"`
+}
+
+type backupInfo struct {
+	Name     string `json:"name"`
+	Revision int64  `json:"revision"`
+}
+
+func (b *Backup) serveHTTP() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPPathBackupNow, b.authorized(b.handleBackupNow))
+	mux.HandleFunc(HTTPPathStatus, b.authorized(b.handleStatus))
+	mux.HandleFunc(HTTPPathBackups, b.authorized(b.handleBackups))
+	mux.HandleFunc(HTTPPathDownload, b.authorized(b.handleDownload))
+	mux.HandleFunc(HTTPPathVerify, b.authorized(b.handleVerify))
+	mux.Handle(HTTPPathMetrics, promhttp.Handler())
+
+	addr := fmt.Sprintf(":%d", constants.DefaultBackupPodHTTPPort)
+	logrus.Infof("serving backup HTTP API on %s", addr)
+	logrus.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// authorized wraps h to reject requests without the bearer token of the sidecar.
+// All requests are rejected if the sidecar has no token.
+func (b *Backup) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		ok := len(b.apiToken) != 0 && strings.HasPrefix(auth, bearerPrefix) &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, bearerPrefix)), []byte(b.apiToken)) == 1
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
+
+func (b *Backup) handleBackupNow(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resultc := make(chan backupResult, 1)
+	select {
+	case b.backupNowc <- resultc:
+	case <-req.Context().Done():
+		return
+	}
+	// The backup runs to completion even if the client goes away.
+	var res backupResult
+	select {
+	case res = <-resultc:
+	case <-req.Context().Done():
+		return
+	}
+
+	code := http.StatusOK
+	if len(res.Error) != 0 {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, res)
+}
+
+func (b *Backup) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b.mu.Lock()
+	resp := statusResponse{
+		LastAttempt:       b.lastResult,
+		NextScheduledTime: b.nextScheduledTime,
+	}
+	b.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (b *Backup) handleBackups(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	be, ok := b.selectDestination(w, req)
+	if !ok {
+		return
+	}
+	names, err := be.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list backups: %v", err), http.StatusInternalServerError)
+		return
+	}
+	infos := []backupInfo{}
+	for _, n := range filterAndSortBackups(names) {
+		rev, _ := getRev(n)
+		infos = append(infos, backupInfo{Name: n, Revision: rev})
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (b *Backup) handleDownload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	be, ok := b.selectDestination(w, req)
+	if !ok {
+		return
+	}
+	name, ok := selectBackup(be, w, req)
+	if !ok {
+		return
+	}
+	// The backup is decrypted and decompressed, so that consumers always get a plain etcd snapshot.
+	rc, err := openBackup(be, name, b.keys)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get backup (%s): %v", name, err), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snapshotFileName(name)))
+	w.Header().Set(HTTPHeaderBackupName, name)
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		// The status code has been sent already; the client sees a truncated body.
+		logrus.Errorf("failed to stream backup (%s) after %d bytes: %v", name, n, err)
+		return
+	}
+	logrus.Infof("served backup %s (size: %d)", name, n)
+}
+
+func (b *Backup) handleVerify(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	be, ok := b.selectDestination(w, req)
+	if !ok {
+		return
+	}
+	name, ok := selectBackup(be, w, req)
+	if !ok {
+		return
+	}
+	m, err := Verify(be, b.keys, name)
+	if err == ErrNoManifest {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to verify backup (%s): %v", name, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// selectDestination returns the backend of the destination selected by the "destination" query parameter of req.
+// It writes the error response and returns false if there is no such destination.
+func (b *Backup) selectDestination(w http.ResponseWriter, req *http.Request) (Backend, bool) {
+	name := req.URL.Query().Get("destination")
+	if len(name) == 0 {
+		return b.primary(), true
+	}
+	for _, d := range b.dests {
+		if d.name == name {
+			return d.be, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("destination not found: %s", name), http.StatusNotFound)
+	return nil, false
+}
+
+// selectBackup returns the name of the backup in be selected by the "name" or "revision" query parameter of req.
+// It writes the error response and returns false if the backup can't be selected.
+func selectBackup(be Backend, w http.ResponseWriter, req *http.Request) (string, bool) {
+	var rev int64
+	if r := req.URL.Query().Get("revision"); len(r) != 0 {
+		var err error
+		rev, err = strconv.ParseInt(r, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid revision (%s): %v", r, err), http.StatusBadRequest)
+			return "", false
+		}
+	}
+	// Only names of existing backups are accepted, so that a request can't reach outside of the backups of the cluster.
+	name, err := SelectBackup(be, req.URL.Query().Get("name"), rev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return "", false
+	}
+	return name, true
+}
+
+func (b *Backup) setLastResult(res backupResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastResult = &res
+}
+
+func (b *Backup) setNextScheduledTime(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextScheduledTime = t
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warningf("failed to write HTTP response: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const manifestSuffix = ".manifest.json"
+
+// ErrNoManifest is returned by Verify for backups saved without a manifest.
+var ErrNoManifest = errors.New("backup has no manifest")
+
+// Manifest describes a backup as it was saved, so that it can be verified later.
+// It is stored next to the backup, in an object named after it.
+type Manifest struct {
+	Name string `json:"name"`
+	// SHA256 is the hex encoded SHA-256 of the stored bytes of the backup,
+	// after compression and encryption.
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Revision is the revision of the member when the snapshot was requested, which is
+	// in the name of the backup. MaxRevision is its revision once etcd took the snapshot.
+	// The snapshot is at a revision from Revision to MaxRevision: they are equal unless
+	// the cluster was written to in between.
+	Revision    int64  `json:"revision"`
+	MaxRevision int64  `json:"maxRevision,omitempty"`
+	EtcdVersion string `json:"etcdVersion"`
+	// ClusterID is the hex encoded ID of the etcd cluster the snapshot was taken from.
+	ClusterID string `json:"clusterID"`
+	// Member is the name of the etcd member the snapshot was taken from.
+	Member string    `json:"member"`
+	Time   time.Time `json:"time"`
+}
+
+func manifestName(name string) string {
+	return name + manifestSuffix
+}
+
+func saveManifest(be Backend, m *Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = be.Save(manifestName(m.Name), bytes.NewReader(b), nil)
+	return err
+}
+
+// getManifest returns the manifest of the backup with the given name.
+func getManifest(be Backend, name string) (*Manifest, error) {
+	rc, err := be.Get(manifestName(name))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNoManifest
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	m := &Manifest{}
+	if err = json.NewDecoder(rc).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of backup (%s): %v", name, err)
+	}
+	return m, nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify downloads the backup with the given name from be and checks it against its manifest.
+// It also decrypts the backup with the keys in kr, decompresses it and checks the hash that
+// etcd appends to its snapshots. It returns the manifest of the verified backup.
+func Verify(be Backend, kr Keyring, name string) (*Manifest, error) {
+	m, err := getManifest(be, name)
+	if err != nil {
+		return nil, err
+	}
+	if err = copyVerified(ioutil.Discard, be, kr, name, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// copyVerified writes the snapshot in the backup with the given name to w, and checks
+// the hash etcd appends to it. The backup is also checked against its manifest m,
+// unless m is nil.
+func copyVerified(w io.Writer, be Backend, kr Keyring, name string, m *Manifest) error {
+	rc, err := be.Get(name)
+	if err != nil {
+		return fmt.Errorf("failed to get backup (%s): %v", name, err)
+	}
+	h := sha256.New()
+	cr := &countingReader{r: io.TeeReader(rc, h)}
+	sr, err := unwrapBackup(&multiCloser{Reader: cr, closers: []io.Closer{rc}}, name, kr)
+	if err != nil {
+		return fmt.Errorf("failed to open backup (%s): %v", name, err)
+	}
+	defer sr.Close()
+
+	sw := &snapshotHashWriter{h: sha256.New()}
+	if _, err = io.Copy(io.MultiWriter(w, sw), sr); err != nil {
+		return fmt.Errorf("failed to download backup (%s): %v", name, err)
+	}
+	if err = sw.check(); err != nil {
+		return fmt.Errorf("backup (%s) is corrupted: %v", name, err)
+	}
+	// Hash whatever is left after the end of the snapshot, such as compression trailers.
+	if _, err = io.Copy(ioutil.Discard, cr); err != nil {
+		return fmt.Errorf("failed to download backup (%s): %v", name, err)
+	}
+
+	if m == nil {
+		return nil
+	}
+	if cr.n != m.Size {
+		return fmt.Errorf("backup (%s) is %d bytes; manifest says %d", name, cr.n, m.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("backup (%s) has SHA-256 %s; manifest says %s", name, sum, m.SHA256)
+	}
+	return nil
+}
+
+// snapshotHashWriter checks the SHA-256 of the database that etcd appends to the
+// snapshot written to it.
+type snapshotHashWriter struct {
+	h hash.Hash
+	// tail holds the last bytes written, which are not hashed until more follow.
+	tail []byte
+}
+
+func (sw *snapshotHashWriter) Write(p []byte) (int, error) {
+	sw.tail = append(sw.tail, p...)
+	if len(sw.tail) > sha256.Size {
+		k := len(sw.tail) - sha256.Size
+		sw.h.Write(sw.tail[:k])
+		sw.tail = append(sw.tail[:0], sw.tail[k:]...)
+	}
+	return len(p), nil
+}
+
+// check checks the hash at the end of the snapshot written so far.
+func (sw *snapshotHashWriter) check() error {
+	if len(sw.tail) < sha256.Size {
+		return errors.New("snapshot is too short to hold a hash")
+	}
+	if !bytes.Equal(sw.h.Sum(nil), sw.tail) {
+		return errors.New("snapshot hash mismatch")
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSnapshot returns the bytes of an etcd snapshot of db: the database followed by its SHA-256.
+func testSnapshot(db []byte) []byte {
+	sum := sha256.Sum256(db)
+	return append(append([]byte{}, db...), sum[:]...)
+}
+
+// putBackup stores data as the backup with the given name, along with its manifest.
+// edit, if not nil, changes the manifest before it is saved.
+func putBackup(t *testing.T, be *memBackend, name string, data []byte, edit func(m *Manifest)) {
+	be.put(name, data, time.Now())
+	sum := sha256.Sum256(data)
+	m := &Manifest{Name: name, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data)), Revision: 7}
+	if edit != nil {
+		edit(m)
+	}
+	if err := saveManifest(be, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	snap := testSnapshot(testPlaintext(100 * 1024))
+	corrupt := append([]byte{}, snap...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	otherSum := sha256.Sum256(corrupt)
+	kr := testKeyring()
+	tests := []struct {
+		name string
+		// backup is the name of the backup to verify.
+		backup string
+		// setup stores the backup in be.
+		setup func(be *memBackend, backup string)
+		// wantErr is a substring of the expected error, or "" if Verify succeeds.
+		wantErr string
+	}{{
+		name:   "matching manifest",
+		backup: testBackupName(7),
+		setup:  func(be *memBackend, backup string) { putBackup(t, be, backup, snap, nil) },
+	}, {
+		name:   "encrypted backup with matching manifest",
+		backup: makeBackupName("3.3.1", 7, "", true),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, encryptBytes(t, snap, kr, "key-2"), nil)
+		},
+	}, {
+		name:   "size mismatch",
+		backup: testBackupName(7),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, snap, func(m *Manifest) { m.Size++ })
+		},
+		wantErr: "manifest says 102433",
+	}, {
+		name:   "hash mismatch",
+		backup: testBackupName(7),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, snap, func(m *Manifest) { m.SHA256 = hex.EncodeToString(otherSum[:]) })
+		},
+		wantErr: "manifest says " + hex.EncodeToString(otherSum[:]),
+	}, {
+		name:    "missing manifest",
+		backup:  testBackupName(7),
+		setup:   func(be *memBackend, backup string) { be.put(backup, snap, time.Now()) },
+		wantErr: ErrNoManifest.Error(),
+	}, {
+		name:   "corrupt snapshot hash",
+		backup: testBackupName(7),
+		// The manifest matches the stored bytes, which were corrupted before they were saved.
+		setup:   func(be *memBackend, backup string) { putBackup(t, be, backup, corrupt, nil) },
+		wantErr: "is corrupted",
+	}}
+	for _, tt := range tests {
+		be := newMemBackend()
+		tt.setup(be, tt.backup)
+		m, err := Verify(be, kr, tt.backup)
+		if be.lists != 0 {
+			t.Errorf("%s: Verify listed the backend %d times; want 0", tt.name, be.lists)
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Verify returned error %v; want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Verify failed: %v", tt.name, err)
+			continue
+		}
+		if m.Name != tt.backup || m.Revision != 7 {
+			t.Errorf("%s: Verify returned manifest of %s at revision %d; want %s at revision 7", tt.name, m.Name, m.Revision, tt.backup)
+		}
+	}
+}
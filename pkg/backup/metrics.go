@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "etcd_backup"
+
+// Reasons of failed backup attempts.
+const (
+	reasonListPods          = "list_pods"
+	reasonNoRunningPods     = "no_running_pods"
+	reasonNoReachableMember = "no_reachable_member"
+	reasonSnapshot          = "snapshot"
+	reasonSave              = "save"
+	reasonUnknown           = "unknown"
+)
+
+// Results of backup verifications.
+const (
+	verificationResultSuccess = "success"
+	verificationResultFailure = "failure"
+)
+
+var (
+	attemptsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "attempts_total",
+		Help:      "Total number of backup attempts.",
+	})
+	successesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "successes_total",
+		Help:      "Total number of backups saved successfully.",
+	})
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "failures_total",
+		Help:      "Total number of failed backup attempts by reason.",
+	}, []string{"reason"})
+	destinationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "destination_failures_total",
+		Help:      "Total number of failures to save a backup to a destination.",
+	}, []string{"destination"})
+	skippedNoChangeTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "skipped_no_change_total",
+		Help:      "Total number of backup attempts skipped because nothing changed since the last backup.",
+	})
+	snapshotSizeBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshot_size_bytes",
+		Help:      "Size of saved backups.",
+		// 1MiB to 8GiB
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 14),
+	})
+	snapshotDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshot_duration_seconds",
+		Help:      "Time to receive a snapshot from etcd.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	uploadDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time the storage backend takes to store a snapshot after it was received from etcd.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	lastSuccessTimestampSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last backup saved successfully.",
+	})
+	lastSuccessRevision = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_revision",
+		Help:      "etcd revision of the last backup saved successfully.",
+	})
+	verificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "verifications_total",
+		Help:      "Total number of backup verifications by result.",
+	}, []string{"result"})
+	verificationDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "verification_duration_seconds",
+		Help:      "Time to restore a backup into a temporary etcd and check it.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+	})
+	lastVerificationSuccessTimestampSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_verification_success_timestamp_seconds",
+		Help:      "Unix time of the last successful backup verification.",
+	})
+	memberRevision = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "member_revision",
+		Help:      "Revision reported by each etcd member when choosing the member to take the snapshot from.",
+	}, []string{"member"})
+)
+
+func init() {
+	prometheus.MustRegister(attemptsTotal)
+	prometheus.MustRegister(successesTotal)
+	prometheus.MustRegister(failuresTotal)
+	prometheus.MustRegister(destinationFailuresTotal)
+	prometheus.MustRegister(skippedNoChangeTotal)
+	prometheus.MustRegister(snapshotSizeBytes)
+	prometheus.MustRegister(snapshotDurationSeconds)
+	prometheus.MustRegister(uploadDurationSeconds)
+	prometheus.MustRegister(lastSuccessTimestampSeconds)
+	prometheus.MustRegister(lastSuccessRevision)
+	prometheus.MustRegister(verificationsTotal)
+	prometheus.MustRegister(verificationDurationSeconds)
+	prometheus.MustRegister(lastVerificationSuccessTimestampSeconds)
+	prometheus.MustRegister(memberRevision)
+}
+
+// backupError is the error of a failed backup attempt along with the reason reported in metrics.
+type backupError struct {
+	reason string
+	err    error
+}
+
+func (e *backupError) Error() string {
+	return e.err.Error()
+}
+
+func failureReason(err error) string {
+	if be, ok := err.(*backupError); ok {
+		return be.reason
+	}
+	return reasonUnknown
+}
+
+// snapshotReader records the time the snapshot stream is read to the end and the error reading it.
+// It splits the time to save a snapshot into receiving it from etcd and storing the rest of it.
+//
+// The stream is read by the compressing and encrypting goroutines, which may still be running
+// when the backup was given up on, so the result is guarded by mu.
+type snapshotReader struct {
+	r io.Reader
+
+	mu  sync.Mutex
+	eof time.Time
+	err error
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil {
+		sr.mu.Lock()
+		switch {
+		case err == io.EOF && sr.eof.IsZero():
+			sr.eof = time.Now()
+		case err != io.EOF:
+			sr.err = err
+		}
+		sr.mu.Unlock()
+	}
+	return n, err
+}
+
+// result returns the time the stream was read to the end, if it was, and the error reading it.
+func (sr *snapshotReader) result() (time.Time, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.eof, sr.err
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+)
+
+const PVBackupV1 = "v1"
+
+func init() {
+	RegisterBackend(api.BackupStorageTypePV, newPVBackend)
+}
+
+// pvBackend stores backups as files on the PersistentVolume mounted at constants.BackupMountDir.
+type pvBackend struct {
+	// dir to store backup files in.
+	dir string
+	// dir to write backup files to before they are moved into dir.
+	tmpDir string
+}
+
+func newPVBackend(sp api.EtcdBackupSpec, namespace, clusterName, tmpDir string) (Backend, error) {
+	dir := filepath.Join(constants.BackupMountDir, PVBackupV1, clusterName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &pvBackend{
+		dir:    dir,
+		tmpDir: tmpDir,
+	}, nil
+}
+
+// Save doesn't store meta, since files have no place to keep it. The only metadata
+// saved today, the key ID of encrypted backups, is also in the backup's envelope.
+func (pb *pvBackend) Save(name string, r io.Reader, meta map[string]string) (int64, error) {
+	p, err := pb.path(name)
+	if err != nil {
+		return -1, err
+	}
+	tmpfile, err := ioutil.TempFile(pb.tmpDir, name)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create snapshot tempfile: %v", err)
+	}
+	defer func() {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+	}()
+
+	n, err := io.Copy(tmpfile, r)
+	if err != nil {
+		return -1, fmt.Errorf("failed to save snapshot: %v", err)
+	}
+	if err = tmpfile.Sync(); err != nil {
+		return -1, err
+	}
+	// Rename is atomic, so a backup is either complete or not there at all.
+	if err = os.Rename(tmpfile.Name(), p); err != nil {
+		return -1, err
+	}
+	return n, nil
+}
+
+func (pb *pvBackend) List() ([]string, error) {
+	files, err := ioutil.ReadDir(pb.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	return names, nil
+}
+
+func (pb *pvBackend) Get(name string) (io.ReadCloser, error) {
+	p, err := pb.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, &notFoundError{name: name}
+	}
+	return f, err
+}
+
+func (pb *pvBackend) Delete(name string) error {
+	p, err := pb.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (pb *pvBackend) LastModified(name string) (time.Time, error) {
+	p, err := pb.path(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// path returns the path of the backup file with the given name.
+// It rejects names that would escape the backup directory.
+func (pb *pvBackend) path(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid backup name: %q", name)
+	}
+	return filepath.Join(pb.dir, name), nil
+}
+
+func (pb *pvBackend) TotalSize() (int64, error) {
+	files, err := ioutil.ReadDir(pb.dir)
+	if err != nil {
+		return -1, err
+	}
+	var size int64
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		size += f.Size()
+	}
+	return size, nil
+}
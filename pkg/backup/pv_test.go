@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPVBackend(t *testing.T) (*pvBackend, string) {
+	root, err := ioutil.TempDir("", "pv-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := &pvBackend{dir: filepath.Join(root, "backups"), tmpDir: filepath.Join(root, "tmp")}
+	for _, dir := range []string{pb.dir, pb.tmpDir} {
+		if err = os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pb, root
+}
+
+func TestPVSaveRejectsEscapingNames(t *testing.T) {
+	pb, root := newTestPVBackend(t)
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"", "../escaped", "sub/backup", "/abs"} {
+		if _, err := pb.Save(name, strings.NewReader("data"), nil); err == nil {
+			t.Errorf("Save(%q) succeeded; want error", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("backup escaped the backup directory: %v", err)
+	}
+}
+
+func TestPVSaveGet(t *testing.T) {
+	pb, root := newTestPVBackend(t)
+	defer os.RemoveAll(root)
+
+	name := "3.1.0_0000000000000001_etcd.backup"
+	n, err := pb.Save(name, strings.NewReader("data"), map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("saved %d bytes; want 4", n)
+	}
+	rc, err := pb.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "data" {
+		t.Errorf("got %q; want %q", b, "data")
+	}
+	names, err := pb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != name {
+		t.Errorf("List() = %v; want [%s]", names, name)
+	}
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SelectBackup returns the name of the backup to restore from be.
+// It is the backup named key if key is set, the backup taken at revision rev if rev is set,
+// and the latest backup otherwise.
+func SelectBackup(be Backend, key string, rev int64) (string, error) {
+	names, err := be.List()
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups: %v", err)
+	}
+	bnames := filterAndSortBackups(names)
+
+	switch {
+	case len(key) != 0:
+		for _, n := range bnames {
+			if n == key {
+				return n, nil
+			}
+		}
+		return "", fmt.Errorf("backup (%s) not found", key)
+	case rev != 0:
+		for _, n := range bnames {
+			r, _ := getRev(n)
+			if r == rev {
+				return n, nil
+			}
+		}
+		return "", fmt.Errorf("backup at revision (%d) not found", rev)
+	default:
+		if len(bnames) == 0 {
+			return "", fmt.Errorf("no backup found")
+		}
+		return bnames[len(bnames)-1], nil
+	}
+}
+
+// Fetch downloads the backup with the given name from be into the file dst.
+// The backup is decrypted with the keys in kr and decompressed on the fly.
+func Fetch(be Backend, kr Keyring, name, dst string) (int64, error) {
+	rc, err := openBackup(be, name, kr)
+	if err != nil {
+		return -1, fmt.Errorf("failed to get backup (%s): %v", name, err)
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, backupFilePerm)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, rc)
+	if err != nil {
+		return -1, fmt.Errorf("failed to download backup (%s): %v", name, err)
+	}
+	return n, f.Sync()
+}
+
+// SidecarClient reads backups through the HTTP API of a backup sidecar.
+// Backups are selected like by SelectBackup.
+type SidecarClient struct {
+	// URL is the base URL of the HTTP API of the sidecar.
+	URL string
+	// Destination is the name of the destination backups are read from.
+	// Defaults to the first destination.
+	Destination string
+	// Token is the bearer token of the sidecar.
+	Token string
+}
+
+// Verify verifies the backup selected by key or rev against its manifest and returns the manifest.
+// It returns ErrNoManifest if the backup has no manifest or doesn't exist.
+func (c *SidecarClient) Verify(key string, rev int64) (*Manifest, error) {
+	resp, err := c.get(HTTPPathVerify, key, rev)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNoManifest
+	default:
+		return nil, responseError(resp)
+	}
+	m := &Manifest{}
+	if err := json.NewDecoder(resp.Body).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Fetch downloads the backup selected by key or rev into the file dst.
+// It returns the name of the backup and its size.
+func (c *SidecarClient) Fetch(key string, rev int64, dst string) (string, int64, error) {
+	resp, err := c.get(HTTPPathDownload, key, rev)
+	if err != nil {
+		return "", -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", -1, responseError(resp)
+	}
+	name := resp.Header.Get(HTTPHeaderBackupName)
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, backupFilePerm)
+	if err != nil {
+		return "", -1, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to download backup (%s): %v", name, err)
+	}
+	return name, n, f.Sync()
+}
+
+func (c *SidecarClient) get(path, key string, rev int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(path, key, rev), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", bearerPrefix+c.Token)
+	return http.DefaultClient.Do(req)
+}
+
+func (c *SidecarClient) url(path, key string, rev int64) string {
+	q := url.Values{}
+	if len(c.Destination) != 0 {
+		q.Set("destination", c.Destination)
+	}
+	if len(key) != 0 {
+		q.Set("name", key)
+	}
+	if rev != 0 {
+		q.Set("revision", strconv.FormatInt(rev, 10))
+	}
+	return strings.TrimSuffix(c.URL, "/") + path + "?" + q.Encode()
+}
+
+func responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/backup/schedule"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+	"github.com/sirupsen/logrus"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	restoreCheckMemberName   = "restore-check"
+	restoreCheckClusterToken = "etcd-backup-restore-check"
+	restoreCheckStartTimeout = 1 * time.Minute
+	// restoreCheckStartAttempts is how many times etcd is started on other ports
+	// when one of its ports was taken.
+	restoreCheckStartAttempts = 3
+)
+
+// runVerification verifies the latest backup at the times of sched.
+func (b *Backup) runVerification(sched schedule.Schedule) {
+	for {
+		time.Sleep(sched.Next(time.Now()).Sub(time.Now()))
+
+		name, err := getLatest(b.primary())
+		if err != nil {
+			logrus.Errorf("failed to get latest backup to verify: %v", err)
+			continue
+		}
+		if len(name) == 0 {
+			logrus.Info("skipped verification: no backup yet")
+			continue
+		}
+		b.verifyBackup(name)
+	}
+}
+
+// runVerifier verifies the backups queued by queueVerification, one at a time.
+func (b *Backup) runVerifier() {
+	for name := range b.verifyc {
+		b.verifyBackup(name)
+	}
+}
+
+// queueVerification queues the backup with the given name for verification, without
+// waiting for it. A backup still waiting to be verified is replaced, since only the
+// newest backup matters.
+func (b *Backup) queueVerification(name string) {
+	for {
+		select {
+		case b.verifyc <- name:
+			return
+		default:
+		}
+		select {
+		case old := <-b.verifyc:
+			logrus.Infof("skipped verification of backup %s: superseded by %s", old, name)
+		default:
+		}
+	}
+}
+
+// verifyBackup restores the backup with the given name into a temporary etcd,
+// and records the result in the status and metrics.
+func (b *Backup) verifyBackup(name string) {
+	start := time.Now()
+	res, err := restoreCheck(b.primary(), b.keys, name)
+	verificationDurationSeconds.Observe(time.Since(start).Seconds())
+
+	res.Time = metav1.Now()
+	res.BackupName = name
+	if err != nil {
+		logrus.Errorf("failed to verify backup %s: %v", name, err)
+		res.Error = err.Error()
+		verificationsTotal.WithLabelValues(verificationResultFailure).Inc()
+	} else {
+		logrus.Infof("verified backup %s (revision: %d, keys: %d, hash: %d)", name, res.Revision, res.KeyCount, res.HashKV)
+		res.Succeeded = true
+		verificationsTotal.WithLabelValues(verificationResultSuccess).Inc()
+		lastVerificationSuccessTimestampSeconds.Set(float64(res.Time.Unix()))
+	}
+	b.reportStatus(func(s *api.EtcdBackupStatus) { s.RecordVerification(res) })
+}
+
+// restoreCheck downloads the backup with the given name from be, restores it into
+// a temporary data dir and serves it with an embedded etcd on loopback.
+// The backup is checked against its manifest, if it has one, while it is downloaded.
+// The restored etcd must be at the revision in the backup name or, if the cluster was
+// written to while the snapshot was requested, at most at the MaxRevision of the manifest.
+// It reads the number of keys and the hash of the key-value store.
+func restoreCheck(be Backend, kr Keyring, name string) (api.VerificationResult, error) {
+	var res api.VerificationResult
+	minRev, err := getRev(name)
+	if err != nil {
+		return res, err
+	}
+	maxRev := minRev
+	m, err := getManifest(be, name)
+	switch err {
+	case nil:
+		if m.MaxRevision > maxRev {
+			maxRev = m.MaxRevision
+		}
+	case ErrNoManifest:
+	default:
+		return res, err
+	}
+
+	dir, err := ioutil.TempDir("", "restore-check")
+	if err != nil {
+		return res, fmt.Errorf("failed to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "snapshot.db")
+	f, err := os.OpenFile(dbPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, backupFilePerm)
+	if err != nil {
+		return res, err
+	}
+	err = copyVerified(f, be, kr, name, m)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+
+	e, clientURL, err := startRestored(dbPath, filepath.Join(dir, "data"))
+	if err != nil {
+		return res, err
+	}
+	defer e.Close()
+
+	etcdcli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: constants.DefaultDialTimeout,
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to create etcd client: %v", err)
+	}
+	defer etcdcli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+	getResp, err := etcdcli.Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	cancel()
+	if err != nil {
+		return res, fmt.Errorf("failed to count keys: %v", err)
+	}
+	res.Revision = getResp.Header.Revision
+	res.KeyCount = getResp.Count
+	switch {
+	case minRev == maxRev && res.Revision != minRev:
+		return res, fmt.Errorf("restored etcd is at revision %d; backup was taken at %d", res.Revision, minRev)
+	case res.Revision < minRev || res.Revision > maxRev:
+		return res, fmt.Errorf("restored etcd is at revision %d; backup was taken between %d and %d", res.Revision, minRev, maxRev)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), constants.DefaultRequestTimeout)
+	hashResp, err := etcdcli.HashKV(ctx, clientURL.String(), res.Revision)
+	cancel()
+	if err != nil {
+		return res, fmt.Errorf("failed to hash key-value store: %v", err)
+	}
+	res.HashKV = hashResp.Hash
+	return res, nil
+}
+
+// startRestored restores the snapshot at dbPath into dataDir, and serves it with an embedded
+// etcd on loopback. It returns the etcd and its client URL once it is ready.
+func startRestored(dbPath, dataDir string) (*embed.Etcd, *url.URL, error) {
+	for attempt := 1; ; attempt++ {
+		peerURL, err := loopbackURL()
+		if err != nil {
+			return nil, nil, err
+		}
+		clientURL, err := loopbackURL()
+		if err != nil {
+			return nil, nil, err
+		}
+		// The peer URL is stored in the restored data dir, so it is restored again for every attempt.
+		if err = os.RemoveAll(dataDir); err != nil {
+			return nil, nil, err
+		}
+		if err = restoreSnapshot(dbPath, dataDir, restoreCheckMemberName, restoreCheckClusterToken, *peerURL); err != nil {
+			return nil, nil, fmt.Errorf("failed to restore snapshot: %v", err)
+		}
+
+		cfg := embed.NewConfig()
+		cfg.Name = restoreCheckMemberName
+		cfg.Dir = dataDir
+		cfg.LPUrls, cfg.APUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+		cfg.LCUrls, cfg.ACUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+		cfg.InitialCluster = cfg.InitialClusterFromName(restoreCheckMemberName)
+		cfg.InitialClusterToken = restoreCheckClusterToken
+		e, err := embed.StartEtcd(cfg)
+		if isAddrInUse(err) && attempt < restoreCheckStartAttempts {
+			logrus.Warningf("failed to start etcd, retrying on other ports: %v", err)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to start etcd: %v", err)
+		}
+		select {
+		case <-e.Server.ReadyNotify():
+			return e, clientURL, nil
+		case err = <-e.Err():
+			err = fmt.Errorf("etcd failed: %v", err)
+		case <-time.After(restoreCheckStartTimeout):
+			err = fmt.Errorf("etcd didn't become ready within %v", restoreCheckStartTimeout)
+		}
+		e.Close()
+		return nil, nil, err
+	}
+}
+
+// loopbackURL returns an http URL on loopback with a free port.
+// The port is only known to be free when loopbackURL returns: it may be taken before it is bound again.
+func loopbackURL() (*url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}, nil
+}
+
+func isAddrInUse(err error) bool {
+	oe, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	se, ok := oe.Err.(*os.SyscallError)
+	return ok && se.Err == syscall.EADDRINUSE
+}
@@ -0,0 +1,296 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+)
+
+// testCluster is the state of an etcd a snapshot was taken of.
+type testCluster struct {
+	snapshot []byte
+	rev      int64
+	hash     uint32
+	kvs      map[string]string
+}
+
+// startTestEtcd starts a single member etcd on loopback, with its data dir in dir.
+func startTestEtcd(t *testing.T, dir string) (*embed.Etcd, *clientv3.Client) {
+	for attempt := 1; ; attempt++ {
+		peerURL, err := loopbackURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientURL, err := loopbackURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := embed.NewConfig()
+		cfg.Name = "source"
+		cfg.Dir = dir
+		cfg.LPUrls, cfg.APUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+		cfg.LCUrls, cfg.ACUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+		cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+		e, err := embed.StartEtcd(cfg)
+		if isAddrInUse(err) && attempt < restoreCheckStartAttempts {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-e.Server.ReadyNotify()
+		cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+		if err != nil {
+			e.Close()
+			t.Fatal(err)
+		}
+		return e, cli
+	}
+}
+
+// newTestCluster writes keys to a new etcd, deletes some of them and takes a snapshot.
+func newTestCluster(t *testing.T) *testCluster {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e, cli := startTestEtcd(t, dir)
+	defer e.Close()
+	defer cli.Close()
+
+	ctx := context.Background()
+	tc := &testCluster{kvs: map[string]string{}}
+	for i := 0; i < 50; i++ {
+		k, v := fmt.Sprintf("/registry/pods/pod-%02d", i), fmt.Sprintf("spec-%d", i)
+		if _, err = cli.Put(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+		tc.kvs[k] = v
+	}
+	for i := 0; i < 50; i += 10 {
+		k := fmt.Sprintf("/registry/pods/pod-%02d", i)
+		if _, err = cli.Delete(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		delete(tc.kvs, k)
+	}
+	resp, err := cli.Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.rev = resp.Header.Revision
+	hresp, err := cli.HashKV(ctx, cli.Endpoints()[0], tc.rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.hash = hresp.Hash
+
+	rc, err := cli.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if tc.snapshot, err = ioutil.ReadAll(rc); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func writeSnapshotFile(t *testing.T, dir string, snapshot []byte) string {
+	p := filepath.Join(dir, "snapshot.db")
+	if err := ioutil.WriteFile(p, snapshot, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	tc := newTestCluster(t)
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e, clientURL, err := startRestored(writeSnapshotFile(t, dir, tc.snapshot), filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+	resp, err := cli.Get(ctx, "/registry/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Revision != tc.rev {
+		t.Errorf("restored etcd is at revision %d, want %d", resp.Header.Revision, tc.rev)
+	}
+	got := map[string]string{}
+	for _, kv := range resp.Kvs {
+		got[string(kv.Key)] = string(kv.Value)
+	}
+	if len(got) != len(tc.kvs) {
+		t.Errorf("restored %d keys, want %d", len(got), len(tc.kvs))
+	}
+	for k, v := range tc.kvs {
+		if got[k] != v {
+			t.Errorf("key %s is %q, want %q", k, got[k], v)
+		}
+	}
+	hresp, err := cli.HashKV(ctx, clientURL.String(), tc.rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hresp.Hash != tc.hash {
+		t.Errorf("restored key-value store has hash %d, want %d", hresp.Hash, tc.hash)
+	}
+
+	// The member of the source cluster is replaced by the restored one.
+	mresp, err := cli.MemberList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mresp.Members) != 1 || mresp.Members[0].Name != restoreCheckMemberName {
+		t.Errorf("restored cluster has members %v, want only %s", mresp.Members, restoreCheckMemberName)
+	}
+}
+
+func TestRestoreSnapshotFails(t *testing.T) {
+	tc := newTestCluster(t)
+	corrupt := append([]byte{}, tc.snapshot...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	tests := []struct {
+		name     string
+		snapshot []byte
+		// dataDirExists creates the data dir before the restore.
+		dataDirExists bool
+		wantErr       string
+	}{
+		{name: "corrupt database", snapshot: corrupt, wantErr: "integrity check failed"},
+		{name: "no hash", snapshot: tc.snapshot[:len(tc.snapshot)-sha256.Size], wantErr: "no integrity hash"},
+		{name: "data dir exists", snapshot: tc.snapshot, dataDirExists: true, wantErr: "exists"},
+	}
+	peerURL := url.URL{Scheme: "http", Host: "127.0.0.1:2380"}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataDir := filepath.Join(dir, "data")
+		if tt.dataDirExists {
+			if err = os.Mkdir(dataDir, 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = restoreSnapshot(writeSnapshotFile(t, dir, tt.snapshot), dataDir, restoreCheckMemberName, restoreCheckClusterToken, peerURL)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: restoreSnapshot returned %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRestoreCheck(t *testing.T) {
+	tc := newTestCluster(t)
+	kr := testKeyring()
+	compressed, err := ioutil.ReadAll(compress(bytes.NewReader(tc.snapshot), api.BackupCompressionGzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := encryptBytes(t, compressed, kr, "key-1")
+	withRevs := func(rev, maxRev int64) func(m *Manifest) {
+		return func(m *Manifest) { m.Revision, m.MaxRevision = rev, maxRev }
+	}
+
+	tests := []struct {
+		name string
+		// backup is the name of the backup to check.
+		backup string
+		// setup stores the backup in be.
+		setup   func(be *memBackend, backup string)
+		wantErr string
+	}{{
+		name:   "exact revision",
+		backup: testBackupName(tc.rev),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, tc.snapshot, withRevs(tc.rev, tc.rev))
+		},
+	}, {
+		name:   "compressed and encrypted",
+		backup: makeBackupName("3.3.1", tc.rev, api.BackupCompressionGzip, true),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, encrypted, withRevs(tc.rev, tc.rev))
+		},
+	}, {
+		name:   "no manifest",
+		backup: testBackupName(tc.rev),
+		setup:  func(be *memBackend, backup string) { be.put(backup, tc.snapshot, time.Now()) },
+	}, {
+		name:   "cluster written to while the snapshot was requested",
+		backup: testBackupName(tc.rev - 2),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, tc.snapshot, withRevs(tc.rev-2, tc.rev+1))
+		},
+	}, {
+		name:   "revision mismatch",
+		backup: testBackupName(tc.rev - 1),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, tc.snapshot, withRevs(tc.rev-1, tc.rev-1))
+		},
+		wantErr: fmt.Sprintf("restored etcd is at revision %d; backup was taken at %d", tc.rev, tc.rev-1),
+	}, {
+		name:    "revision mismatch without manifest",
+		backup:  testBackupName(tc.rev + 1),
+		setup:   func(be *memBackend, backup string) { be.put(backup, tc.snapshot, time.Now()) },
+		wantErr: fmt.Sprintf("restored etcd is at revision %d; backup was taken at %d", tc.rev, tc.rev+1),
+	}, {
+		name:   "manifest mismatch",
+		backup: testBackupName(tc.rev),
+		setup: func(be *memBackend, backup string) {
+			putBackup(t, be, backup, tc.snapshot, func(m *Manifest) { m.Revision, m.Size = tc.rev, m.Size-1 })
+		},
+		wantErr: "manifest says",
+	}}
+	for _, tt := range tests {
+		be := newMemBackend()
+		tt.setup(be, tt.backup)
+		res, err := restoreCheck(be, kr, tt.backup)
+		if n := be.gets[tt.backup]; n != 1 {
+			t.Errorf("%s: downloaded the backup %d times, want once", tt.name, n)
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: restoreCheck returned error %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: restoreCheck failed: %v", tt.name, err)
+			continue
+		}
+		if res.Revision != tc.rev || res.KeyCount != int64(len(tc.kvs)) || res.HashKV != tc.hash {
+			t.Errorf("%s: restored revision %d with %d keys and hash %d, want revision %d with %d keys and hash %d",
+				tt.name, res.Revision, res.KeyCount, res.HashKV, tc.rev, len(tc.kvs), tc.hash)
+		}
+	}
+}
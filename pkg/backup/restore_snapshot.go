@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/etcdserver/membership"
+	"github.com/coreos/etcd/lease"
+	"github.com/coreos/etcd/mvcc"
+	"github.com/coreos/etcd/mvcc/backend"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// restoreSnapshot restores the etcd snapshot at dbPath into dataDir, as the data dir
+// of the only member of a new cluster, named name and reachable at peerURL.
+// It follows "etcdctl snapshot restore" of the etcd version the operator is built with.
+func restoreSnapshot(dbPath, dataDir, name, token string, peerURL url.URL) error {
+	urlmap, err := types.NewURLsMap(fmt.Sprintf("%s=%s", name, peerURL.String()))
+	if err != nil {
+		return err
+	}
+	cl, err := membership.NewClusterFromURLsMap(token, urlmap)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(dataDir); err == nil {
+		return fmt.Errorf("data dir %s exists", dataDir)
+	}
+	snapDir := filepath.Join(dataDir, "member", "snap")
+	if err = makeDB(snapDir, dbPath, len(cl.Members())); err != nil {
+		return err
+	}
+	return makeWALAndSnap(filepath.Join(dataDir, "member", "wal"), snapDir, name, cl)
+}
+
+// makeDB copies the snapshot at dbPath into snapDir, after checking its integrity hash,
+// and removes the members of the old cluster from it.
+func makeDB(snapDir, dbPath string, commit int) error {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Snapshots end with the sha256 of their content.
+	if _, err = f.Seek(-sha256.Size, io.SeekEnd); err != nil {
+		return err
+	}
+	sha := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(f, sha); err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err = fileutil.CreateDirAll(snapDir); err != nil {
+		return err
+	}
+	dbp := filepath.Join(snapDir, "db")
+	db, err := os.OpenFile(dbp, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = io.Copy(db, f); err != nil {
+		return err
+	}
+
+	// The database is a multiple of the page size; the hash is what is left over.
+	off, err := db.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if off%512 != sha256.Size {
+		return fmt.Errorf("snapshot has no integrity hash")
+	}
+	if err = db.Truncate(off - sha256.Size); err != nil {
+		return err
+	}
+	if _, err = db.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, db); err != nil {
+		return err
+	}
+	if dbsha := h.Sum(nil); !bytes.Equal(sha, dbsha) {
+		return fmt.Errorf("snapshot integrity check failed: expected sha256 %x, got %x", sha, dbsha)
+	}
+	if err = db.Close(); err != nil {
+		return err
+	}
+
+	be := backend.NewDefaultBackend(dbp)
+	defer be.Close()
+	// The lessor never expires leases.
+	lessor := lease.NewLessor(be, math.MaxInt64)
+	// The consistent index is set to the entries of the new WAL, so that the new
+	// raft log is applied on top of the snapshot.
+	s := mvcc.NewStore(be, lessor, (*initIndex)(&commit))
+	defer s.Close()
+	txn := s.Write()
+	btx := be.BatchTx()
+	del := func(k, v []byte) error {
+		txn.DeleteRange(k, nil)
+		return nil
+	}
+	btx.UnsafeForEach([]byte("members"), del)
+	btx.UnsafeForEach([]byte("members_removed"), del)
+	txn.End()
+	s.Commit()
+	return nil
+}
+
+// initIndex is the consistent index of a restored store.
+type initIndex int
+
+func (i *initIndex) ConsistentIndex() uint64 { return uint64(*i) }
+
+// makeWALAndSnap creates the WAL and v2 store snapshot of the new cluster cl,
+// as seen by its member with the given name.
+func makeWALAndSnap(walDir, snapDir, name string, cl *membership.RaftCluster) error {
+	if err := fileutil.CreateDirAll(walDir); err != nil {
+		return err
+	}
+
+	// Members are added again to persist them to the new store.
+	st := store.New(etcdserver.StoreClusterPrefix, etcdserver.StoreKeysPrefix)
+	cl.SetStore(st)
+	for _, m := range cl.Members() {
+		cl.AddMember(m)
+	}
+
+	m := cl.MemberByName(name)
+	if m == nil {
+		return fmt.Errorf("member %s not found in the new cluster", name)
+	}
+	md := &etcdserverpb.Metadata{NodeID: uint64(m.ID), ClusterID: uint64(cl.ID())}
+	metadata, err := md.Marshal()
+	if err != nil {
+		return err
+	}
+	w, err := wal.Create(walDir, metadata)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	ids := cl.MemberIDs()
+	peers := make([]raft.Peer, len(ids))
+	for i, id := range ids {
+		ctx, err := json.Marshal(cl.Member(id))
+		if err != nil {
+			return err
+		}
+		peers[i] = raft.Peer{ID: uint64(id), Context: ctx}
+	}
+	ents := make([]raftpb.Entry, len(peers))
+	nodeIDs := make([]uint64, len(peers))
+	for i, p := range peers {
+		nodeIDs[i] = p.ID
+		cc := raftpb.ConfChange{
+			Type:    raftpb.ConfChangeAddNode,
+			NodeID:  p.ID,
+			Context: p.Context,
+		}
+		d, err := cc.Marshal()
+		if err != nil {
+			return err
+		}
+		ents[i] = raftpb.Entry{
+			Type:  raftpb.EntryConfChange,
+			Term:  1,
+			Index: uint64(i + 1),
+			Data:  d,
+		}
+	}
+
+	commit, term := uint64(len(ents)), uint64(1)
+	if err = w.Save(raftpb.HardState{Term: term, Vote: peers[0].ID, Commit: commit}, ents); err != nil {
+		return err
+	}
+
+	b, err := st.Save()
+	if err != nil {
+		return err
+	}
+	raftSnap := raftpb.Snapshot{
+		Data: b,
+		Metadata: raftpb.SnapshotMetadata{
+			Index:     commit,
+			Term:      term,
+			ConfState: raftpb.ConfState{Nodes: nodeIDs},
+		},
+	}
+	if err = snap.New(snapDir).SaveSnap(raftSnap); err != nil {
+		return err
+	}
+	return w.SaveSnapshot(walpb.Snapshot{Index: commit, Term: term})
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+// prune deletes the backups that fall outside the retention policy from all destinations.
+// The newest backup is never deleted.
+func (b *Backup) prune() error {
+	if b.spec.Retention == nil {
+		return nil
+	}
+
+	var lastErr error
+	for _, d := range b.dests {
+		if err := pruneBackend(d.be, b.spec.Retention); err != nil {
+			logrus.Errorf("failed to prune destination (%s): %v", d.name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func pruneBackend(be Backend, r *api.RetentionPolicy) error {
+	names, err := be.List()
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %v", err)
+	}
+	// sorted from the oldest to the newest.
+	bnames := filterAndSortBackups(names)
+	if len(bnames) <= 1 {
+		return nil
+	}
+	candidates := bnames[:len(bnames)-1]
+
+	var expired []string
+	if r.MaxBackups > 0 && len(bnames) > r.MaxBackups {
+		n := len(bnames) - r.MaxBackups
+		expired = append(expired, candidates[:n]...)
+		candidates = candidates[n:]
+	}
+	if r.MaxAge != nil {
+		for _, name := range candidates {
+			lm, err := be.LastModified(name)
+			if err != nil {
+				return fmt.Errorf("failed to get last modified time of backup (%s): %v", name, err)
+			}
+			// Backups are sorted by revision, which doesn't order them by age: a backup
+			// may be rewritten, or copied into the storage, after newer ones were saved.
+			// So each backup's age is checked on its own.
+			if time.Since(lm) > r.MaxAge.Duration {
+				expired = append(expired, name)
+			}
+		}
+	}
+
+	var lastErr error
+	for _, name := range expired {
+		if err := be.Delete(name); err != nil {
+			logrus.Errorf("failed to prune backup (%s): %v", name, err)
+			lastErr = err
+			continue
+		}
+		logrus.Infof("pruned backup (%s)", name)
+		if containsName(names, manifestName(name)) {
+			if err := be.Delete(manifestName(name)); err != nil {
+				logrus.Errorf("failed to prune manifest of backup (%s): %v", name, err)
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
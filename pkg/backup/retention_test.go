@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testBackupName(rev int64) string {
+	return makeBackupName("3.3.1", rev, "", false)
+}
+
+func TestPruneBackend(t *testing.T) {
+	hour := &metav1.Duration{Duration: time.Hour}
+	tests := []struct {
+		name   string
+		policy api.RetentionPolicy
+		// ages of the backups of revisions 1, 2, ...
+		ages []time.Duration
+		// revisions of the backups that are left.
+		want []int64
+	}{{
+		name:   "max backups",
+		policy: api.RetentionPolicy{MaxBackups: 2},
+		ages:   []time.Duration{5 * time.Minute, 4 * time.Minute, 3 * time.Minute, 2 * time.Minute, time.Minute},
+		want:   []int64{4, 5},
+	}, {
+		name:   "fewer backups than max backups",
+		policy: api.RetentionPolicy{MaxBackups: 5},
+		ages:   []time.Duration{2 * time.Minute, time.Minute},
+		want:   []int64{1, 2},
+	}, {
+		name:   "max age",
+		policy: api.RetentionPolicy{MaxAge: hour},
+		ages:   []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, time.Minute},
+		want:   []int64{3, 4},
+	}, {
+		name:   "max backups and max age",
+		policy: api.RetentionPolicy{MaxBackups: 3, MaxAge: hour},
+		ages:   []time.Duration{50 * time.Minute, 40 * time.Minute, 2 * time.Hour, 20 * time.Minute, 10 * time.Minute},
+		want:   []int64{4, 5},
+	}, {
+		name:   "newest backup is kept when too old",
+		policy: api.RetentionPolicy{MaxAge: hour},
+		ages:   []time.Duration{3 * time.Hour, 2 * time.Hour},
+		want:   []int64{2},
+	}, {
+		name:   "newest backup is kept with max backups 1",
+		policy: api.RetentionPolicy{MaxBackups: 1, MaxAge: hour},
+		ages:   []time.Duration{2 * time.Minute, 2 * time.Hour},
+		want:   []int64{2},
+	}, {
+		// Ages don't follow revisions, e.g. when an old backup was copied into the storage
+		// after newer ones: each backup is aged on its own.
+		name:   "ages out of revision order",
+		policy: api.RetentionPolicy{MaxAge: hour},
+		ages:   []time.Duration{2 * time.Hour, 10 * time.Minute, 3 * time.Hour, 2 * time.Hour, time.Minute},
+		want:   []int64{2, 5},
+	}}
+	for _, tt := range tests {
+		be := newMemBackend()
+		now := time.Now()
+		for i, age := range tt.ages {
+			be.put(testBackupName(int64(i+1)), []byte("backup"), now.Add(-age))
+		}
+		policy := tt.policy
+		if err := pruneBackend(be, &policy); err != nil {
+			t.Errorf("%s: pruneBackend failed: %v", tt.name, err)
+			continue
+		}
+		if got := backupRevs(t, be); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: left backups of revisions %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPruneBackendDeletesManifests(t *testing.T) {
+	be := newMemBackend()
+	now := time.Now()
+	for rev := int64(1); rev <= 3; rev++ {
+		name := testBackupName(rev)
+		be.put(name, []byte("backup"), now)
+		be.put(manifestName(name), []byte("{}"), now)
+	}
+	// A backup saved without a manifest is pruned too.
+	be.put(testBackupName(4), []byte("backup"), now)
+	be.put(testBackupName(5), []byte("backup"), now)
+	be.put("unrelated", []byte("data"), now.Add(-24*time.Hour))
+
+	if err := pruneBackend(be, &api.RetentionPolicy{MaxBackups: 1}); err != nil {
+		t.Fatal(err)
+	}
+	names, err := be.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testBackupName(5), "unrelated"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("left objects %v; want %v", names, want)
+	}
+}
+
+// backupRevs returns the revisions of the backups in be, in ascending order.
+func backupRevs(t *testing.T, be Backend) []int64 {
+	names, err := be.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revs []int64
+	for _, name := range filterAndSortBackups(names) {
+		rev, err := getRev(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+	}
+	return revs
+}
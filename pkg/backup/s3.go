@@ -15,31 +15,96 @@
 package backup
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
-	"strings"
+	"time"
 
-	"github.com/Sirupsen/logrus"
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
 	"github.com/coreos/etcd-backup-operator/pkg/backup/s3"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
 )
 
 const (
-	backupFilePerm       = 0600
-	backupFilenameSuffix = "etcd.backup"
+	AWSS3Bucket = "AWS_S3_BUCKET"
+	S3V1        = "v1"
 )
 
+func init() {
+	RegisterBackend(api.BackupStorageTypeS3, newS3Backend)
+}
+
+func ToS3Prefix(s3Prefix, namespace, clusterName string) string {
+	return path.Join(s3Prefix, S3V1, namespace, clusterName)
+}
+
 type s3Backend struct {
 	S3 *s3.S3
 	// dir to temporarily store backup files before upload it to S3.
 	dir string
+	// multipart streams backups in multipart uploads instead of storing them in dir; nil to use dir.
+	multipart *api.S3MultipartUpload
+}
+
+func newS3Backend(sp api.EtcdBackupSpec, namespace, clusterName, tmpDir string) (Backend, error) {
+	if sp.S3 == nil {
+		return nil, errors.New("s3 storage source is not set")
+	}
+	opts := s3.Options{
+		Endpoint:       sp.S3.Endpoint,
+		Region:         sp.S3.Region,
+		ForcePathStyle: sp.S3.ForcePathStyle,
+	}
+	if len(sp.S3.CASecret) != 0 {
+		ca, err := ioutil.ReadFile(filepath.Join(constants.S3CADir, constants.S3CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read S3 CA bundle: %v", err)
+		}
+		opts.CABundle = ca
+	}
+	s3cli, err := s3.NewWithOptions(os.Getenv(AWSS3Bucket), ToS3Prefix(sp.S3.Prefix, namespace, clusterName), opts)
+	if err != nil {
+		return nil, err
+	}
+	multipart, err := resolveMultipartUpload(sp.S3.MultipartUpload)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{
+		dir:       tmpDir,
+		S3:        s3cli,
+		multipart: multipart,
+	}, nil
+}
+
+// resolveMultipartUpload returns a copy of mu with defaults filled in.
+// It fails on part sizes S3 would reject, rather than on the first upload.
+func resolveMultipartUpload(mu *api.S3MultipartUpload) (*api.S3MultipartUpload, error) {
+	if mu == nil {
+		return nil, nil
+	}
+	r := *mu
+	switch {
+	case r.PartSizeInMB == 0:
+		r.PartSizeInMB = constants.DefaultS3PartSizeInMB
+	case r.PartSizeInMB < constants.MinS3PartSizeInMB:
+		return nil, fmt.Errorf("multipart upload part size %dMB is below the S3 minimum of %dMB", r.PartSizeInMB, constants.MinS3PartSizeInMB)
+	}
+	if r.Concurrency <= 0 {
+		r.Concurrency = constants.DefaultS3UploadConcurrency
+	}
+	return &r, nil
 }
 
-func (sb *s3Backend) save(version string, snapRev int64, rc io.Reader) (int64, error) {
+func (sb *s3Backend) Save(key string, rc io.Reader, meta map[string]string) (int64, error) {
+	if sb.multipart != nil {
+		return sb.saveStream(key, rc, meta)
+	}
 	// make a local file copy of the backup first, since s3 requires io.ReadSeeker.
-	key := makeBackupName(version, snapRev)
 	tmpfile, err := os.OpenFile(filepath.Join(sb.dir, key), os.O_RDWR|os.O_CREATE, backupFilePerm)
 	if err != nil {
 		return -1, fmt.Errorf("failed to create snapshot tempfile: %v", err)
@@ -58,74 +123,43 @@ func (sb *s3Backend) save(version string, snapRev int64, rc io.Reader) (int64, e
 		return -1, err
 	}
 	// S3 put is atomic, so let's go ahead and put the key directly.
-	err = sb.S3.Put(key, tmpfile)
+	err = sb.S3.Put(key, tmpfile, meta)
 	if err != nil {
 		return -1, err
 	}
-	logrus.Infof("saved backup %s (size: %d) successfully", key, n)
 	return n, nil
 }
 
-func makeBackupName(ver string, rev int64) string {
-	return fmt.Sprintf("%s_%016x_%s", ver, rev, backupFilenameSuffix)
-}
-
-func (sb *s3Backend) getLatest() (string, error) {
-	keys, err := sb.S3.List()
-	if err != nil {
-		return "", fmt.Errorf("failed to list s3 bucket: %v", err)
+func (sb *s3Backend) saveStream(key string, r io.Reader, meta map[string]string) (int64, error) {
+	partSize := int64(sb.multipart.PartSizeInMB) * 1024 * 1024
+	cr := &countingReader{r: r}
+	// The multipart upload completes only if all parts are uploaded, so the key is never seen partially written.
+	if err := sb.S3.PutStream(key, cr, meta, partSize, sb.multipart.Concurrency); err != nil {
+		return -1, err
 	}
-
-	return getLatestBackupName(keys), nil
+	return cr.n, nil
 }
 
-func getLatestBackupName(names []string) string {
-	bnames := filterAndSortBackups(names)
-	if len(bnames) == 0 {
-		return ""
-	}
-	return bnames[len(bnames)-1]
+func (sb *s3Backend) List() ([]string, error) {
+	return sb.S3.List()
 }
 
-type backupNames []string
-
-func (bn backupNames) Len() int { return len(bn) }
-
-func (bn backupNames) Less(i, j int) bool {
-	ri, err := getRev(bn[i])
-	if err != nil {
-		panic(err)
-	}
-	rj, err := getRev(bn[j])
-	if err != nil {
-		panic(err)
+func (sb *s3Backend) Get(key string) (io.ReadCloser, error) {
+	rc, err := sb.S3.Get(key)
+	if s3.IsNotFound(err) {
+		return nil, &notFoundError{name: key}
 	}
-
-	return ri < rj
+	return rc, err
 }
 
-func (bn backupNames) Swap(i, j int) {
-	bn[i], bn[j] = bn[j], bn[i]
+func (sb *s3Backend) Delete(key string) error {
+	return sb.S3.Delete(key)
 }
 
-func filterAndSortBackups(names []string) []string {
-	bnames := make(backupNames, 0)
-	for _, n := range names {
-		if !isBackup(n) {
-			continue
-		}
-		_, err := getRev(n)
-		if err != nil {
-			logrus.Errorf("fail to get rev from backup (%s): %v", n, err)
-			continue
-		}
-		bnames = append(bnames, n)
-	}
-
-	sort.Sort(bnames)
-	return []string(bnames)
+func (sb *s3Backend) TotalSize() (int64, error) {
+	return sb.S3.TotalSize()
 }
 
-func isBackup(name string) bool {
-	return strings.HasSuffix(name, backupFilenameSuffix)
+func (sb *s3Backend) LastModified(key string) (time.Time, error) {
+	return sb.S3.LastModified(key)
 }
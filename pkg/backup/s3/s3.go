@@ -15,20 +15,25 @@
 package s3
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"path"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // S3 is a helper layer to wrap complex S3 logic.
 type S3 struct {
 	bucket string
 	prefix string
-	client *s3.S3
+	client s3iface.S3API
 }
 
 // New returns a S3 translator from default shared config.
@@ -42,6 +47,38 @@ func New(bucket, prefix string) (*S3, error) {
 	})
 }
 
+// Options configures the S3 service to talk to.
+// Zero values fall back to the default shared config.
+type Options struct {
+	// Endpoint is the URL of an S3 compatible service.
+	Endpoint string
+	Region   string
+	// ForcePathStyle puts the bucket in the URL path instead of the host name.
+	ForcePathStyle bool
+	// CABundle is the PEM encoded CA bundle to trust the endpoint with.
+	CABundle []byte
+}
+
+// NewWithOptions returns a S3 translator from default shared config overridden by opts.
+func NewWithOptions(bucket, prefix string, opts Options) (*S3, error) {
+	so := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if len(opts.Endpoint) != 0 {
+		so.Config.Endpoint = aws.String(opts.Endpoint)
+	}
+	if len(opts.Region) != 0 {
+		so.Config.Region = aws.String(opts.Region)
+	}
+	if opts.ForcePathStyle {
+		so.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if len(opts.CABundle) != 0 {
+		so.CustomCABundle = bytes.NewReader(opts.CABundle)
+	}
+	return NewFromSessionOpt(bucket, prefix, so)
+}
+
 func NewFromSessionOpt(bucket, prefix string, so session.Options) (*S3, error) {
 	sess, err := session.NewSessionWithOptions(so)
 	if err != nil {
@@ -52,7 +89,8 @@ func NewFromSessionOpt(bucket, prefix string, so session.Options) (*S3, error) {
 	return NewFromClient(bucket, prefix, cli), nil
 }
 
-func NewFromClient(bucket, prefix string, cli *s3.S3) *S3 {
+// NewFromClient returns a S3 translator that talks to S3 through cli.
+func NewFromClient(bucket, prefix string, cli s3iface.S3API) *S3 {
 	return &S3{
 		bucket: bucket,
 		prefix: prefix,
@@ -60,11 +98,33 @@ func NewFromClient(bucket, prefix string, cli *s3.S3) *S3 {
 	}
 }
 
-func (s *S3) Put(key string, rs io.ReadSeeker) error {
+// Put uploads the content of rs, storing meta as the object's user metadata.
+func (s *S3) Put(key string, rs io.ReadSeeker, meta map[string]string) error {
 	_, err := s.client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(path.Join(s.prefix, key)),
-		Body:   rs,
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(path.Join(s.prefix, key)),
+		Body:     rs,
+		Metadata: aws.StringMap(meta),
+	})
+
+	return err
+}
+
+// PutStream uploads the content of r in a multipart upload of parts of partSize bytes,
+// concurrency of which are uploaded in parallel.
+// The upload is aborted if it fails, so that no parts are left behind.
+// meta is stored as the object's user metadata.
+func (s *S3) PutStream(key string, r io.Reader, meta map[string]string, partSize int64, concurrency int) error {
+	u := s3manager.NewUploaderWithClient(s.client, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+		u.LeavePartsOnError = false
+	})
+	_, err := u.Upload(&s3manager.UploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(path.Join(s.prefix, key)),
+		Body:     r,
+		Metadata: aws.StringMap(meta),
 	})
 
 	return err
@@ -82,6 +142,24 @@ func (s *S3) Get(key string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// IsNotFound tells whether err is the error Get returns for keys that don't exist.
+func IsNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+func (s *S3) LastModified(key string) (time.Time, error) {
+	resp, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return aws.TimeValue(resp.LastModified), nil
+}
+
 func (s *S3) Delete(key string) error {
 	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -96,25 +174,28 @@ func (s *S3) List() ([]string, error) {
 	return l, err
 }
 
+// list returns the total size and the keys of the objects under prefix.
+// It walks every page of the listing, since S3 returns at most 1000 keys per page.
 func (s *S3) list(prefix string) (int64, []string, error) {
-	resp, err := s.client.ListObjects(&s3.ListObjectsInput{
+	// s3 doesn't have dir. It only recognizes prefix.
+	// Thus "a/b" has prefix "a/"
+	dir := prefix + "/"
+	keys := []string{}
+	var size int64
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		// s3 doesn't have dir. It only recognizes prefix.
-		// Thus "a/b" has prefix "a/"
-		Prefix: aws.String(prefix + "/"),
+		Prefix: aws.String(dir),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key)[len(dir):])
+			size += aws.Int64Value(obj.Size)
+		}
+		return true
 	})
 	if err != nil {
 		return -1, nil, err
 	}
 
-	keys := []string{}
-	var size int64
-	for _, key := range resp.Contents {
-		k := (*key.Key)[len(*resp.Prefix):]
-		keys = append(keys, k)
-		size += *key.Size
-	}
-
 	return size, keys, nil
 }
 
@@ -0,0 +1,133 @@
+// Copyright 2017 The etcd-operator Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// maxKeys is the most keys S3 returns in one page of a listing.
+	maxKeys = 1000
+
+	testPrefix = "v1/default/example"
+)
+
+// fakeS3 serves listings of its objects in pages of at most maxKeys keys.
+type fakeS3 struct {
+	s3iface.S3API
+	objects []*s3.Object
+	pages   int
+}
+
+func newFakeS3(n int) *fakeS3 {
+	f := &fakeS3{}
+	for i := 1; i <= n; i++ {
+		f.objects = append(f.objects, &s3.Object{
+			Key:  aws.String(fmt.Sprintf("%s/3.1.0_%016x_etcd.backup", testPrefix, i)),
+			Size: aws.Int64(int64(i)),
+		})
+	}
+	// The keys of cluster "example-2" start with testPrefix too. List must skip them.
+	f.objects = append(f.objects, &s3.Object{
+		Key:  aws.String(testPrefix + "-2/3.1.0_0000000000000001_etcd.backup"),
+		Size: aws.Int64(1),
+	})
+	return f
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var objs []*s3.Object
+	for _, o := range f.objects {
+		if strings.HasPrefix(aws.StringValue(o.Key), aws.StringValue(in.Prefix)) {
+			objs = append(objs, o)
+		}
+	}
+	for start := 0; ; start += maxKeys {
+		end := start + maxKeys
+		if end > len(objs) {
+			end = len(objs)
+		}
+		last := end == len(objs)
+		f.pages++
+		page := &s3.ListObjectsV2Output{
+			Contents:    objs[start:end],
+			KeyCount:    aws.Int64(int64(end - start)),
+			IsTruncated: aws.Bool(!last),
+		}
+		if !fn(page, last) || last {
+			return nil
+		}
+	}
+}
+
+// GetObject fails like S3 does for every key: the fake stores no object data.
+func (f *fakeS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+}
+
+func TestGetNotFound(t *testing.T) {
+	s := NewFromClient("bucket", testPrefix, newFakeS3(0))
+
+	_, err := s.Get("3.1.0_0000000000000001_etcd.backup.manifest.json")
+	if !IsNotFound(err) {
+		t.Errorf("Get of a missing key returned %v, want a not found error", err)
+	}
+	if IsNotFound(awserr.New("AccessDenied", "Access Denied", nil)) {
+		t.Error("IsNotFound is true for an access denied error")
+	}
+}
+
+func TestListAllPages(t *testing.T) {
+	n := 2*maxKeys + 500
+	f := newFakeS3(n)
+	s := NewFromClient("bucket", testPrefix, f)
+
+	keys, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.pages != 3 {
+		t.Errorf("listed %d pages, want 3", f.pages)
+	}
+	if len(keys) != n {
+		t.Fatalf("listed %d keys, want %d", len(keys), n)
+	}
+	for i, k := range keys {
+		if want := fmt.Sprintf("3.1.0_%016x_etcd.backup", i+1); k != want {
+			t.Fatalf("key %d is %s, want %s", i, k, want)
+		}
+	}
+}
+
+func TestTotalSizeAllPages(t *testing.T) {
+	n := 2*maxKeys + 500
+	s := NewFromClient("bucket", testPrefix, newFakeS3(n))
+
+	size, err := s.TotalSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(n * (n + 1) / 2); size != want {
+		t.Errorf("total size is %d, want %d", size, want)
+	}
+}
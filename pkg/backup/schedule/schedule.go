@@ -0,0 +1,73 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+
+	"github.com/robfig/cron"
+)
+
+// Schedule decides when backups are taken.
+type Schedule interface {
+	// Next returns the time of the first backup after t.
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule takes a backup every interval.
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule takes backups at the times of a cron expression in a time zone.
+type cronSchedule struct {
+	sched cron.Schedule
+	loc   *time.Location
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	return s.sched.Next(t.In(s.loc))
+}
+
+// New returns the schedule of sp.
+// sp.Schedule takes precedence over sp.BackupIntervalInSecond.
+func New(sp api.EtcdBackupSpec) (Schedule, error) {
+	if len(sp.Schedule) == 0 {
+		interval := constants.DefaultSnapshotInterval
+		if sp.BackupIntervalInSecond != 0 {
+			interval = time.Duration(sp.BackupIntervalInSecond) * time.Second
+		}
+		return &intervalSchedule{interval: interval}, nil
+	}
+
+	return NewCron(sp.Schedule, sp.TimeZone)
+}
+
+// NewCron returns the schedule of the cron expression expr in time zone tz.
+// The time zone defaults to UTC.
+func NewCron(expr, tz string) (Schedule, error) {
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule (%s): %v", expr, err)
+	}
+	loc := time.UTC
+	if len(tz) != 0 {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone (%s): %v", tz, err)
+		}
+	}
+	return &cronSchedule{sched: sched, loc: loc}, nil
+}
+
+// IntervalAt returns the time between the first two backups after t.
+func IntervalAt(s Schedule, t time.Time) time.Duration {
+	n := s.Next(t)
+	return s.Next(n).Sub(n)
+}
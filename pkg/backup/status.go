@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const maxStatusUpdateRetries = 5
+
+// reportStatus applies f to the status of the EtcdBackup CR and writes it back.
+// A failure to update the status is logged but doesn't fail the backup itself.
+func (b *Backup) reportStatus(f func(*api.EtcdBackupStatus)) {
+	if err := b.updateStatus(f); err != nil {
+		logrus.Warningf("failed to update status of backup (%s): %v", b.name, err)
+	}
+}
+
+func (b *Backup) updateStatus(f func(*api.EtcdBackupStatus)) error {
+	for i := 0; i < maxStatusUpdateRetries; i++ {
+		eb, err := b.backupCRCli.Get(context.TODO(), b.namespace, b.name)
+		if err != nil {
+			return err
+		}
+		f(&eb.Status)
+		_, err = b.backupCRCli.Update(context.TODO(), eb)
+		if err == nil || !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d conflicting updates", maxStatusUpdateRetries)
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+
+	"k8s.io/client-go/rest"
+)
+
+type RestoreCR interface {
+	RESTClient() *rest.RESTClient
+
+	Get(ctx context.Context, namespace, name string) (*api.EtcdRestore, error)
+	Create(ctx context.Context, restore *api.EtcdRestore) (*api.EtcdRestore, error)
+	Delete(ctx context.Context, namespace, name string) error
+	Update(ctx context.Context, restore *api.EtcdRestore) (*api.EtcdRestore, error)
+}
+
+func MustNewRestoreInCluster() RestoreCR {
+	cfg, err := k8sutil.InClusterConfig()
+	if err != nil {
+		panic(err)
+	}
+	cli, err := NewRestoreCRClient(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return cli
+}
+
+func NewRestoreCRClient(cfg *rest.Config) (RestoreCR, error) {
+	cli, _, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &restoreCR{restCli: cli}, nil
+}
+
+type restoreCR struct {
+	restCli *rest.RESTClient
+}
+
+func (rcr *restoreCR) RESTClient() *rest.RESTClient {
+	return rcr.restCli
+}
+
+func (rcr *restoreCR) Get(ctx context.Context, namespace, name string) (*api.EtcdRestore, error) {
+	er := &api.EtcdRestore{}
+	err := rcr.restCli.Get().Context(ctx).
+		Namespace(namespace).
+		Resource(api.RestoreCRDResourcePlural).
+		Name(name).
+		Do().
+		Into(er)
+	return er, err
+}
+
+func (rcr *restoreCR) Create(ctx context.Context, restore *api.EtcdRestore) (*api.EtcdRestore, error) {
+	er := &api.EtcdRestore{}
+	err := rcr.restCli.Post().Context(ctx).
+		Namespace(restore.Namespace).
+		Resource(api.RestoreCRDResourcePlural).
+		Body(restore).
+		Do().
+		Into(er)
+	return er, err
+}
+
+func (rcr *restoreCR) Delete(ctx context.Context, namespace, name string) error {
+	return rcr.restCli.Delete().Context(ctx).
+		Namespace(namespace).
+		Resource(api.RestoreCRDResourcePlural).
+		Name(name).
+		Do().
+		Error()
+}
+
+func (rcr *restoreCR) Update(ctx context.Context, restore *api.EtcdRestore) (*api.EtcdRestore, error) {
+	er := &api.EtcdRestore{}
+	err := rcr.restCli.Put().Context(ctx).
+		Namespace(restore.Namespace).
+		Resource(api.RestoreCRDResourcePlural).
+		Name(restore.Name).
+		Body(restore).
+		Do().
+		Into(er)
+	return er, err
+}
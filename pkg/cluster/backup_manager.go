@@ -2,10 +2,15 @@ package cluster
 
 import (
 	"fmt"
+	"reflect"
 
 	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
 	k8sutil "github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+	"github.com/sirupsen/logrus"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -15,6 +20,8 @@ type backupManager struct {
 	serviceAccount string
 }
 
+// New returns the manager of the sidecar of backup.
+// It fails if the spec of backup is invalid, which retrying doesn't fix.
 func New(kubeCli kubernetes.Interface, serviceAccount string, backup *api.EtcdBackup) (*backupManager, error) {
 	if kubeCli == nil {
 		return nil, fmt.Errorf("kubeCli not defined")
@@ -22,31 +29,148 @@ func New(kubeCli kubernetes.Interface, serviceAccount string, backup *api.EtcdBa
 	if backup == nil {
 		return nil, fmt.Errorf("backup not defined")
 	}
+	// The sidecar would fail on invalid destinations too, but only in its logs.
+	if err := backup.Spec.ValidateDestinations(); err != nil {
+		return nil, fmt.Errorf("invalid destinations: %v", err)
+	}
+	if err := backup.Spec.ValidateMemberSelection(); err != nil {
+		return nil, err
+	}
 	return &backupManager{kubeCli, backup, serviceAccount}, nil
 }
 
 func (bm *backupManager) Setup() error {
+	for _, d := range bm.backup.Spec.GetDestinations() {
+		if d.StorageType != api.BackupStorageTypePV {
+			continue
+		}
+		if err := bm.createBackupPVC(d.PV); err != nil {
+			return fmt.Errorf("failed to create backup PVC: %v", err)
+		}
+	}
 	return bm.runSidecar()
 }
 
+// createBackupPVC creates the PVC backups are stored on if it doesn't exist yet.
+// The PVC isn't owned by the EtcdBackup, so that deleting the EtcdBackup doesn't delete its backups.
+func (bm *backupManager) createBackupPVC(pv *api.PVSource) error {
+	pvProvisioner := constants.PVProvisionerNone
+	volumeSizeInMB := constants.DefaultBackupVolumeSizeInMB
+	if pv != nil {
+		if len(pv.PVProvisioner) != 0 {
+			pvProvisioner = pv.PVProvisioner
+		}
+		if pv.VolumeSizeInMB != 0 {
+			volumeSizeInMB = pv.VolumeSizeInMB
+		}
+	}
+	if !k8sutil.IsValidPVProvisioner(pvProvisioner) {
+		return fmt.Errorf("unsupported PV provisioner: %v", pvProvisioner)
+	}
+
+	err := k8sutil.CreateStorageClass(bm.kubeCli, pvProvisioner)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	pvc := k8sutil.NewBackupPVC(k8sutil.BackupPVCName(bm.backup.Name), bm.backup.Spec.ClusterName, pvProvisioner, volumeSizeInMB)
+	_, err = bm.kubeCli.CoreV1().PersistentVolumeClaims(bm.backup.Namespace).Create(pvc)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
 func (bm *backupManager) runSidecar() error {
-	if err := bm.createSidecarDeployment(); err != nil {
-		return fmt.Errorf("failed to create backup sidecar Deployment: %v", err)
+	if err := bm.createAPITokenSecret(); err != nil {
+		return fmt.Errorf("failed to create backup sidecar API token Secret: %v", err)
+	}
+	if err := bm.syncSidecarDeployment(); err != nil {
+		return fmt.Errorf("failed to sync backup sidecar Deployment: %v", err)
+	}
+	if err := bm.syncSidecarService(); err != nil {
+		return fmt.Errorf("failed to sync backup sidecar Service: %v", err)
 	}
 	return nil
 }
 
-func (bm *backupManager) createSidecarDeployment() error {
+// createAPITokenSecret creates the Secret holding the bearer token of the HTTP API of the sidecar
+// if it doesn't exist yet. The token is kept across updates of the EtcdBackup.
+func (bm *backupManager) createAPITokenSecret() error {
+	b := bm.backup
+	secret, err := k8sutil.NewBackupAPITokenSecret(b.Name, b.Spec.ClusterName, k8sutil.AsOwner(b))
+	if err != nil {
+		return err
+	}
+	_, err = bm.kubeCli.CoreV1().Secrets(b.Namespace).Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// syncSidecarService creates the Service that exposes the HTTP API of the sidecar if it doesn't exist yet,
+// and points it at the sidecar of this EtcdBackup only if it selects other pods.
+func (bm *backupManager) syncSidecarService() error {
+	b := bm.backup
+	svc := k8sutil.NewBackupServiceManifest(k8sutil.BackupSidecarName(b.Name), b.Spec.ClusterName, b.Name, k8sutil.AsOwner(b))
+	scli := bm.kubeCli.CoreV1().Services(b.Namespace)
+	_, err := scli.Create(svc)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	old, err := scli.Get(svc.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec.Selector, svc.Spec.Selector) {
+		return nil
+	}
+	logrus.Infof("updating selector of backup sidecar Service (%s)", svc.Name)
+	old.Spec.Selector = svc.Spec.Selector
+	_, err = scli.Update(old)
+	return err
+}
+
+// syncSidecarDeployment makes the sidecar Deployment match the EtcdBackup spec.
+// It creates the Deployment if it doesn't exist and updates it if it is outdated.
+func (bm *backupManager) syncSidecarDeployment() error {
 	d := bm.makeSidecarDeployment()
-	_, err := bm.kubeCli.AppsV1beta1().Deployments(bm.backup.Namespace).Create(d)
+	dcli := bm.kubeCli.AppsV1beta1().Deployments(bm.backup.Namespace)
+	old, err := dcli.Get(d.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("creating backup sidecar Deployment (%s)", d.Name)
+		_, err = dcli.Create(d)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if k8sutil.GetSpecHash(old) == k8sutil.GetSpecHash(d) {
+		return nil
+	}
+
+	logrus.Infof("updating backup sidecar Deployment (%s)", d.Name)
+	old.Labels = d.Labels
+	k8sutil.SetSpecHash(old, k8sutil.GetSpecHash(d))
+	old.Spec.Selector = d.Spec.Selector
+	old.Spec.Template = d.Spec.Template
+	old.Spec.Strategy = d.Spec.Strategy
+	_, err = dcli.Update(old)
 	return err
 }
 
 func (bm *backupManager) makeSidecarDeployment() *appsv1beta1.Deployment {
 	b := bm.backup
-	podTemplate := k8sutil.NewBackupPodTemplate(bm.serviceAccount, b.Spec)
-	k8sutil.AttachS3ToPodSpec(&podTemplate.Spec, b.Spec.S3)
+	podTemplate := k8sutil.NewBackupPodTemplate(bm.serviceAccount, b.Name, b.Spec)
+	ps := &podTemplate.Spec
+	k8sutil.AttachStorageToPodSpec(ps, &ps.Containers[0], b.Name, b.Spec)
+	if len(b.Spec.ClientTLSSecret) != 0 {
+		k8sutil.AttachClientTLSToPodSpec(ps, &ps.Containers[0], b.Spec.ClientTLSSecret)
+	}
 	name := k8sutil.BackupSidecarName(b.Name)
 	dplSel := k8sutil.LabelsForCluster(b.Spec.ClusterName)
-	return k8sutil.NewBackupDeploymentManifest(name, dplSel, podTemplate, k8sutil.AsOwner(b))
+	d := k8sutil.NewBackupDeploymentManifest(name, dplSel, podTemplate, k8sutil.AsOwner(b))
+	k8sutil.SetSpecHash(d, k8sutil.HashDeploymentSpec(d))
+	return d
 }
@@ -2,17 +2,21 @@ package operator
 
 import (
 	"context"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
 
+const resyncPeriod = 1 * time.Minute
+
 func (b *Backup) run(ctx context.Context) {
 	source := cache.NewListWatchFromClient(
 		b.backupCRCli.RESTClient(),
@@ -21,26 +25,47 @@ func (b *Backup) run(ctx context.Context) {
 		fields.Everything(),
 	)
 
-	b.queue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "backup-operator")
-	b.indexer, b.informer = cache.NewIndexerInformer(source, &api.EtcdBackup{}, 0, cache.ResourceEventHandlerFuncs{
+	b.queue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), backupQueueName)
+	// Resyncing periodically recreates sidecar Deployments that were deleted.
+	b.indexer, b.informer = cache.NewIndexerInformer(source, &api.EtcdBackup{}, resyncPeriod, cache.ResourceEventHandlerFuncs{
 		AddFunc:    b.onAdd,
 		UpdateFunc: b.onUpdate,
 		DeleteFunc: b.onDelete,
 	}, cache.Indexers{})
 
+	restoreSource := cache.NewListWatchFromClient(
+		b.restoreCRCli.RESTClient(),
+		api.RestoreCRDResourcePlural,
+		b.namespace,
+		fields.Everything(),
+	)
+
+	b.restoreQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), restoreQueueName)
+	b.restoreIndexer, b.restoreInformer = cache.NewIndexerInformer(restoreSource, &api.EtcdRestore{}, resyncPeriod, cache.ResourceEventHandlerFuncs{
+		AddFunc:    b.onAddRestore,
+		UpdateFunc: b.onUpdateRestore,
+	}, cache.Indexers{})
+
 	defer b.queue.ShutDown()
+	defer b.restoreQueue.ShutDown()
 
 	logrus.Info("starting backup controller")
 	go b.informer.Run(ctx.Done())
+	go b.restoreInformer.Run(ctx.Done())
+
+	registerQueueDepth(backupQueueName, b.queue)
+	registerQueueDepth(restoreQueueName, b.restoreQueue)
 
-	if !cache.WaitForCacheSync(ctx.Done(), b.informer.HasSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), b.informer.HasSynced, b.restoreInformer.HasSynced) {
 		logrus.Error("Timed out waiting for caches to sync")
 		return
 	}
+	atomic.StoreInt32(&b.syncing, 0)
 
 	const numWorkers = 1
 	for i := 0; i < numWorkers; i++ {
 		go wait.Until(b.runWorker, time.Second, ctx.Done())
+		go wait.Until(b.runRestoreWorker, time.Second, ctx.Done())
 	}
 
 	<-ctx.Done()
@@ -56,6 +81,12 @@ func (b *Backup) onAdd(obj interface{}) {
 }
 
 func (b *Backup) onUpdate(oldObj, newObj interface{}) {
+	oldEB, newEB := oldObj.(*api.EtcdBackup), newObj.(*api.EtcdBackup)
+	// Status updates by the operator and the sidecar don't need a sync.
+	// Periodic resyncs deliver identical objects and still go through.
+	if oldEB.ResourceVersion != newEB.ResourceVersion && reflect.DeepEqual(oldEB.Spec, newEB.Spec) {
+		return
+	}
 	key, err := cache.MetaNamespaceKeyFunc(newObj)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,115 @@
+package operator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const metricsNamespace = "etcd_backup_operator"
+
+var (
+	workqueueRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "workqueue_retries_total",
+		Help:      "Total number of keys requeued after a failed sync.",
+	}, []string{"queue"})
+	workqueueDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "workqueue_dropped_total",
+		Help:      "Total number of keys dropped out of the queue after too many failed syncs.",
+	}, []string{"queue"})
+	workqueueWorkDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "workqueue_work_duration_seconds",
+		Help:      "Time it takes to sync a key taken from the queue.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"queue"})
+	workqueueQueueDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "workqueue_queue_duration_seconds",
+		Help:      "Time a key waits in the queue before it is synced.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"queue"})
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of EtcdBackup syncs by result.",
+	}, []string{"backup", "result"})
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "is_leader",
+		Help:      "1 if this operator instance is the leader, 0 otherwise.",
+	})
+	managedSidecars = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "managed_sidecar_deployments",
+		Help:      "Number of backup sidecar Deployments managed by the operator.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(workqueueRetriesTotal)
+	prometheus.MustRegister(workqueueDroppedTotal)
+	prometheus.MustRegister(workqueueWorkDurationSeconds)
+	prometheus.MustRegister(workqueueQueueDurationSeconds)
+	prometheus.MustRegister(reconcileTotal)
+	prometheus.MustRegister(isLeader)
+	prometheus.MustRegister(managedSidecars)
+	workqueue.SetProvider(queueMetricsProvider{})
+}
+
+// queueMetricsProvider exports how long keys wait in the named workqueues.
+// Depth, retries and work duration are exported by the operator itself,
+// so the other metrics of the workqueue are dropped.
+type queueMetricsProvider struct{}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	return queueLatencyMetric{queue: name}
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+// queueLatencyMetric records the latencies the workqueue reports in microseconds in seconds.
+type queueLatencyMetric struct {
+	queue string
+}
+
+func (m queueLatencyMetric) Observe(us float64) {
+	workqueueQueueDurationSeconds.WithLabelValues(m.queue).Observe(us / 1e6)
+}
+
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Observe(float64) {}
+
+// deleteReconcileMetrics removes the reconcile_total series of the EtcdBackup with key.
+func deleteReconcileMetrics(key string) {
+	reconcileTotal.DeleteLabelValues(key, "success")
+	reconcileTotal.DeleteLabelValues(key, "error")
+}
+
+// registerQueueDepth exports the depth of queue q.
+func registerQueueDepth(name string, q workqueue.Interface) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "workqueue_depth",
+		Help:        "Number of keys waiting in the queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 { return float64(q.Len()) }))
+}
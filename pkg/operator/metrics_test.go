@@ -0,0 +1,75 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// collect returns the metrics of c whose label name has the given value.
+func collect(t *testing.T, c prometheus.Collector, name, value string) []*dto.Metric {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	var ms []*dto.Metric
+	for m := range ch {
+		d := &dto.Metric{}
+		if err := m.Write(d); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range d.Label {
+			if l.GetName() == name && l.GetValue() == value {
+				ms = append(ms, d)
+			}
+		}
+	}
+	return ms
+}
+
+func TestReconcileMetricsOfDeletedBackup(t *testing.T) {
+	eb := newTestBackupCR("metrics")
+	b := newTestBackup(eb)
+	defer b.queue.ShutDown()
+	defer b.restoreQueue.ShutDown()
+	key := testNamespace + "/metrics"
+
+	b.indexer.Add(eb)
+	b.queue.Add(key)
+	b.processNextItem()
+	if ms := collect(t, reconcileTotal, "backup", key); len(ms) != 1 {
+		t.Fatalf("got %d reconcile_total series of backup (%s); want 1", len(ms), key)
+	}
+
+	b.indexer.Delete(eb)
+	b.queue.Add(key)
+	b.processNextItem()
+	if ms := collect(t, reconcileTotal, "backup", key); len(ms) != 0 {
+		t.Errorf("got %d reconcile_total series of deleted backup (%s); want 0", len(ms), key)
+	}
+}
+
+func TestQueueDuration(t *testing.T) {
+	q := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "duration-test")
+	defer q.ShutDown()
+	q.Add("key")
+	key, _ := q.Get()
+	q.Done(key)
+
+	ms := collect(t, workqueueQueueDurationSeconds, "queue", "duration-test")
+	if len(ms) != 1 || ms[0].GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("queue duration of key wasn't recorded: %v", ms)
+	}
+}
+
+func TestReady(t *testing.T) {
+	b := &Backup{}
+	if !b.Ready() {
+		t.Error("standby operator isn't ready")
+	}
+	b.syncing = 1
+	if b.Ready() {
+		t.Error("operator is ready while its caches sync")
+	}
+}
@@ -3,19 +3,22 @@ package operator
 import (
 	"context"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/coreos/etcd-backup-operator/pkg/client"
 	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
 	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
 	"github.com/coreos/etcd-operator/pkg/util/retryutil"
+	"github.com/sirupsen/logrus"
 
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -28,24 +31,53 @@ type Backup struct {
 	informer cache.Controller
 	queue    workqueue.RateLimitingInterface
 
+	restoreIndexer  cache.Indexer
+	restoreInformer cache.Controller
+	restoreQueue    workqueue.RateLimitingInterface
+
 	kubecli       kubernetes.Interface
 	backupCRCli   client.BackupCR
+	restoreCRCli  client.RestoreCR
 	kubeExtClient apiextensionsclient.Interface
+	recorder      record.EventRecorder
+
+	// syncing is 1 from the start of the operator until its informer caches are synced.
+	syncing int32
+
+	mu sync.Mutex
+	// sidecars holds the keys of the EtcdBackups whose sidecar Deployment is managed by the operator.
+	sidecars map[string]struct{}
 }
 
 // New creates a backup operator.
 func New() *Backup {
+	namespace := os.Getenv(constants.EnvOperatorPodNamespace)
+	name := os.Getenv(constants.EnvOperatorPodName)
+	kubecli := k8sutil.MustNewKubeClient()
 	return &Backup{
-		namespace:     os.Getenv(constants.EnvOperatorPodNamespace),
-		name:          os.Getenv(constants.EnvOperatorPodName),
-		kubecli:       k8sutil.MustNewKubeClient(),
+		namespace:     namespace,
+		name:          name,
+		kubecli:       kubecli,
 		backupCRCli:   client.MustNewInCluster(),
+		restoreCRCli:  client.MustNewRestoreInCluster(),
 		kubeExtClient: k8sutil.MustNewKubeExtClient(),
+		recorder:      k8sutil.NewEventRecorder(kubecli, name, namespace),
+		sidecars:      map[string]struct{}{},
 	}
 }
 
+// Ready tells whether the informer caches of the operator are synced.
+// Standby instances don't run informers until they lead, so they are ready.
+func (b *Backup) Ready() bool {
+	return atomic.LoadInt32(&b.syncing) == 0
+}
+
 // Start starts the Backup operator.
+// It must only be called once this instance became the leader.
 func (b *Backup) Start(ctx context.Context) error {
+	atomic.StoreInt32(&b.syncing, 1)
+	isLeader.Set(1)
+	defer isLeader.Set(0)
 	err := b.init(ctx)
 	if err != nil {
 		return err
@@ -57,8 +89,12 @@ func (b *Backup) Start(ctx context.Context) error {
 
 func (b *Backup) init(ctx context.Context) error {
 	err := k8sutil.CreateBackupCRD(b.kubeExtClient)
-	if apierrors.IsAlreadyExists(err) {
-		return nil
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	err = k8sutil.CreateRestoreCRD(b.kubeExtClient)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
 	}
 	b.serviceAccount, err = b.getMyPodServiceAccount()
 	return err
@@ -0,0 +1,276 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/etcdutil"
+	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// restoreCheckInterval is the interval at which a running restore is checked for completion.
+	restoreCheckInterval = 5 * time.Second
+	// maxInitContainerRestarts is the number of times the init containers of the seed member
+	// are restarted before the restore fails.
+	maxInitContainerRestarts = 3
+)
+
+// checkHealth checks whether the etcd member serving at the given URL is healthy.
+var checkHealth = etcdutil.CheckHealth
+
+func (b *Backup) onAddRestore(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		panic(err)
+	}
+	b.restoreQueue.Add(key)
+}
+
+func (b *Backup) onUpdateRestore(oldObj, newObj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(newObj)
+	if err != nil {
+		panic(err)
+	}
+	b.restoreQueue.Add(key)
+}
+
+func (b *Backup) runRestoreWorker() {
+	for b.processNextRestore() {
+	}
+}
+
+func (b *Backup) processNextRestore() bool {
+	key, quit := b.restoreQueue.Get()
+	if quit {
+		return false
+	}
+	defer b.restoreQueue.Done(key)
+
+	start := time.Now()
+	err := b.processRestore(key.(string))
+	workqueueWorkDurationSeconds.WithLabelValues(restoreQueueName).Observe(time.Since(start).Seconds())
+	b.handleRestoreErr(err, key)
+	return true
+}
+
+func (b *Backup) handleRestoreErr(err error, key interface{}) {
+	if !handleQueueErr(b.restoreQueue, restoreQueueName, err, key) {
+		return
+	}
+
+	obj, exists, gerr := b.restoreIndexer.GetByKey(key.(string))
+	if gerr != nil || !exists {
+		return
+	}
+	er := obj.(*api.EtcdRestore)
+	ref := &v1.ObjectReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       api.RestoreCRDResourceKind,
+		Namespace:  er.Namespace,
+		Name:       er.Name,
+		UID:        er.UID,
+	}
+	b.recorder.Eventf(ref, v1.EventTypeWarning, eventReasonSyncFailed, "failed to sync restore after %d retries: %v", maxRetries, err)
+}
+
+func (b *Backup) processRestore(key string) error {
+	obj, exists, err := b.restoreIndexer.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	cobj, err := scheme.Scheme.DeepCopy(obj)
+	if err != nil {
+		return err
+	}
+	er := cobj.(*api.EtcdRestore)
+
+	switch er.Status.Phase {
+	case api.RestorePhaseNone:
+		return b.acceptRestore(er)
+	case api.RestorePhasePending:
+		return b.startRestore(er)
+	case api.RestorePhaseRestoring:
+		return b.checkRestore(key, er)
+	}
+	// A completed restore hands the cluster over to its owner. A lost seed member isn't recreated:
+	// its PVC may hold data the owner of the cluster has moved on from.
+	return nil
+}
+
+// acceptRestore validates er and moves it to the Pending phase.
+func (b *Backup) acceptRestore(er *api.EtcdRestore) error {
+	rs := er.Spec
+	if len(rs.BackupName) == 0 || len(rs.ClusterName) == 0 {
+		return b.failRestore(er, "backupName and clusterName must be set")
+	}
+	eb, err := b.backupCRCli.Get(context.TODO(), er.Namespace, rs.BackupName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return b.failRestore(er, fmt.Sprintf("backup (%s) not found", rs.BackupName))
+		}
+		return err
+	}
+	if _, ok := eb.Spec.GetDestination(rs.Destination); !ok {
+		return b.failRestore(er, fmt.Sprintf("destination (%s) not found in backup (%s)", rs.Destination, rs.BackupName))
+	}
+
+	er.Status.Phase = api.RestorePhasePending
+	er.Status.Reason = fmt.Sprintf("creating seed member of etcd cluster (%s)", rs.ClusterName)
+	_, err = b.restoreCRCli.Update(context.TODO(), er)
+	return err
+}
+
+// startRestore creates the services, the seed member PVC and the seed member pod of the restored cluster.
+func (b *Backup) startRestore(er *api.EtcdRestore) error {
+	logrus.Infof("starting restore: %s/%s", er.Namespace, er.Name)
+	rs := er.Spec
+	eb, err := b.backupCRCli.Get(context.TODO(), er.Namespace, rs.BackupName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return b.failRestore(er, fmt.Sprintf("backup (%s) not found", rs.BackupName))
+		}
+		return err
+	}
+
+	m := seedMember(er)
+	pod, err := b.kubecli.CoreV1().Pods(er.Namespace).Get(m.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		// The pod was created by a previous attempt that failed to update the status.
+		if !k8sutil.IsRestorePod(pod, er.Name) {
+			return b.failRestore(er, fmt.Sprintf("etcd cluster (%s) already exists", rs.ClusterName))
+		}
+	case apierrors.IsNotFound(err):
+		for _, svc := range []*v1.Service{k8sutil.NewPeerService(rs.ClusterName), k8sutil.NewClientService(rs.ClusterName)} {
+			_, err = b.kubecli.CoreV1().Services(er.Namespace).Create(svc)
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create service (%s): %v", svc.Name, err)
+			}
+		}
+		pvc := k8sutil.NewSeedMemberPVC(m, er)
+		_, err = b.kubecli.CoreV1().PersistentVolumeClaims(er.Namespace).Create(pvc)
+		if apierrors.IsAlreadyExists(err) {
+			pvc, err = b.kubecli.CoreV1().PersistentVolumeClaims(er.Namespace).Get(pvc.Name, metav1.GetOptions{})
+			if err == nil && pvc.Labels[k8sutil.RestoreLabelKey] != er.Name {
+				return b.failRestore(er, fmt.Sprintf("PVC (%s) already exists", pvc.Name))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create seed member PVC: %v", err)
+		}
+		if _, err = b.ensureSeedMember(er, eb); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	now := metav1.Now()
+	er.Status.Phase = api.RestorePhaseRestoring
+	er.Status.Reason = fmt.Sprintf("seeding etcd cluster (%s) from a backup of %s", rs.ClusterName, eb.Spec.ClusterName)
+	er.Status.StartTime = &now
+	_, err = b.restoreCRCli.Update(context.TODO(), er)
+	return err
+}
+
+// checkRestore updates the phase of a running restore from the state of its seed member pod.
+func (b *Backup) checkRestore(key string, er *api.EtcdRestore) error {
+	eb, err := b.backupCRCli.Get(context.TODO(), er.Namespace, er.Spec.BackupName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return b.failRestore(er, fmt.Sprintf("backup (%s) was deleted", er.Spec.BackupName))
+		}
+		return err
+	}
+	pod, err := b.ensureSeedMember(er, eb)
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		b.restoreQueue.AddAfter(key, restoreCheckInterval)
+		return nil
+	}
+
+	if name, t := k8sutil.FailedInitContainer(pod, maxInitContainerRestarts); t != nil {
+		return b.failRestore(er, fmt.Sprintf("%s failed: %s", name, t.Message))
+	}
+	if pod.Status.Phase == v1.PodRunning {
+		m := seedMember(er)
+		if ok, _ := checkHealth(m.ClientURL(), nil); ok {
+			now := metav1.Now()
+			er.Status.Phase = api.RestorePhaseCompleted
+			er.Status.Reason = fmt.Sprintf("etcd cluster (%s) is serving", er.Spec.ClusterName)
+			er.Status.RestoredBackupName = k8sutil.RestoredBackupName(pod)
+			er.Status.CompletionTime = &now
+			_, err = b.restoreCRCli.Update(context.TODO(), er)
+			return err
+		}
+	}
+
+	b.restoreQueue.AddAfter(key, restoreCheckInterval)
+	return nil
+}
+
+// ensureSeedMember makes sure the seed member pod of er exists while it is restoring and returns it.
+// A missing pod is recreated from eb, and a failed pod, such as an evicted one, is deleted to be recreated.
+// The data of the seed member survives on its PVC. It returns nil if the pod isn't there.
+func (b *Backup) ensureSeedMember(er *api.EtcdRestore, eb *api.EtcdBackup) (*v1.Pod, error) {
+	m := seedMember(er)
+	pods := b.kubecli.CoreV1().Pods(er.Namespace)
+	pod, err := pods.Get(m.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("creating seed member pod (%s) of restore %s/%s", m.Name, er.Namespace, er.Name)
+		_, err = pods.Create(k8sutil.NewSeedMemberPod(b.serviceAccount, m, er, eb))
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to create seed member pod: %v", err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !k8sutil.IsRestorePod(pod, er.Name) {
+		return nil, fmt.Errorf("pod (%s) isn't the seed member of restore %s", pod.Name, er.Name)
+	}
+	if pod.Status.Phase == v1.PodFailed {
+		logrus.Warningf("deleting failed seed member pod (%s): %s", pod.Name, pod.Status.Message)
+		err = pods.Delete(pod.Name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &pod.UID}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete failed seed member pod: %v", err)
+		}
+		return nil, nil
+	}
+	return pod, nil
+}
+
+// seedMember returns the first member of the cluster restored by er.
+func seedMember(er *api.EtcdRestore) *etcdutil.Member {
+	return &etcdutil.Member{
+		Name:      etcdutil.CreateMemberName(er.Spec.ClusterName, 0),
+		Namespace: er.Namespace,
+	}
+}
+
+func (b *Backup) failRestore(er *api.EtcdRestore, reason string) error {
+	logrus.Errorf("restore %s/%s failed: %s", er.Namespace, er.Name, reason)
+	now := metav1.Now()
+	er.Status.Phase = api.RestorePhaseFailed
+	er.Status.Reason = reason
+	er.Status.CompletionTime = &now
+	_, err := b.restoreCRCli.Update(context.TODO(), er)
+	return err
+}
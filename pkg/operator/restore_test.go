@@ -0,0 +1,308 @@
+package operator
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/k8sutil"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const testNamespace = "default"
+
+type fakeBackupCR struct {
+	backups map[string]*api.EtcdBackup
+}
+
+func (f *fakeBackupCR) RESTClient() *rest.RESTClient { return nil }
+
+func (f *fakeBackupCR) Get(ctx context.Context, namespace, name string) (*api.EtcdBackup, error) {
+	eb, ok := f.backups[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: api.SchemeGroupVersion.Group, Resource: api.CRDResourcePlural}, name)
+	}
+	return eb, nil
+}
+
+func (f *fakeBackupCR) Create(ctx context.Context, eb *api.EtcdBackup) (*api.EtcdBackup, error) {
+	f.backups[eb.Name] = eb
+	return eb, nil
+}
+
+func (f *fakeBackupCR) Delete(ctx context.Context, namespace, name string) error {
+	delete(f.backups, name)
+	return nil
+}
+
+func (f *fakeBackupCR) Update(ctx context.Context, eb *api.EtcdBackup) (*api.EtcdBackup, error) {
+	f.backups[eb.Name] = eb
+	return eb, nil
+}
+
+// fakeRestoreCR writes EtcdRestores to the indexer the operator reads them from.
+type fakeRestoreCR struct {
+	indexer cache.Indexer
+}
+
+func (f *fakeRestoreCR) RESTClient() *rest.RESTClient { return nil }
+
+func (f *fakeRestoreCR) Get(ctx context.Context, namespace, name string) (*api.EtcdRestore, error) {
+	obj, exists, err := f.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: api.SchemeGroupVersion.Group, Resource: api.RestoreCRDResourcePlural}, name)
+	}
+	return obj.(*api.EtcdRestore), nil
+}
+
+func (f *fakeRestoreCR) Create(ctx context.Context, er *api.EtcdRestore) (*api.EtcdRestore, error) {
+	return er, f.indexer.Add(er)
+}
+
+func (f *fakeRestoreCR) Delete(ctx context.Context, namespace, name string) error {
+	return f.indexer.Delete(&api.EtcdRestore{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})
+}
+
+func (f *fakeRestoreCR) Update(ctx context.Context, er *api.EtcdRestore) (*api.EtcdRestore, error) {
+	return er, f.indexer.Update(er)
+}
+
+func newTestBackup(ebs ...*api.EtcdBackup) *Backup {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	b := &Backup{
+		namespace:      testNamespace,
+		serviceAccount: "default",
+		indexer:        cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "backup-test"),
+		restoreIndexer: indexer,
+		restoreQueue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "restore-test"),
+		kubecli:        fake.NewSimpleClientset(),
+		backupCRCli:    &fakeBackupCR{backups: map[string]*api.EtcdBackup{}},
+		restoreCRCli:   &fakeRestoreCR{indexer: indexer},
+		recorder:       record.NewFakeRecorder(10),
+		sidecars:       map[string]struct{}{},
+	}
+	for _, eb := range ebs {
+		b.backupCRCli.Create(context.TODO(), eb)
+	}
+	return b
+}
+
+func newTestBackupCR(name string) *api.EtcdBackup {
+	return &api.EtcdBackup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+		Spec: api.EtcdBackupSpec{
+			ClusterName: "source",
+			StorageType: api.BackupStorageTypePV,
+		},
+	}
+}
+
+func newTestRestoreCR(name, backupName string) *api.EtcdRestore {
+	return &api.EtcdRestore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name, UID: "restore-uid"},
+		Spec: api.EtcdRestoreSpec{
+			BackupName:  backupName,
+			ClusterName: "restored",
+		},
+	}
+}
+
+// syncRestore processes the restore of the given name once and checks that it is in the phase want.
+func syncRestore(t *testing.T, b *Backup, name string, want api.RestorePhase) *api.EtcdRestore {
+	key := testNamespace + "/" + name
+	if err := b.processRestore(key); err != nil {
+		t.Fatalf("failed to process restore: %v", err)
+	}
+	er, err := b.restoreCRCli.Get(context.TODO(), testNamespace, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if er.Status.Phase != want {
+		t.Fatalf("restore is in phase %q (%s); want %q", er.Status.Phase, er.Status.Reason, want)
+	}
+	return er
+}
+
+// startTestRestore runs a restore of a PV backup up to the Restoring phase.
+func startTestRestore(t *testing.T) *Backup {
+	b := newTestBackup(newTestBackupCR("backup"))
+	b.restoreCRCli.Create(context.TODO(), newTestRestoreCR("restore", "backup"))
+	syncRestore(t, b, "restore", api.RestorePhasePending)
+	er := syncRestore(t, b, "restore", api.RestorePhaseRestoring)
+	if er.Status.StartTime == nil {
+		t.Error("StartTime isn't set")
+	}
+	return b
+}
+
+func updateSeedMemberPod(t *testing.T, b *Backup, update func(pod *v1.Pod)) {
+	pods := b.kubecli.CoreV1().Pods(testNamespace)
+	pod, err := pods.Get(seedMember(newTestRestoreCR("restore", "backup")).Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(pod)
+	if _, err = pods.Update(pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreCompletes(t *testing.T) {
+	healthy := false
+	defer func(f func(string, *tls.Config) (bool, error)) { checkHealth = f }(checkHealth)
+	checkHealth = func(string, *tls.Config) (bool, error) { return healthy, nil }
+
+	b := startTestRestore(t)
+	defer b.restoreQueue.ShutDown()
+	kubecli := b.kubecli.CoreV1()
+	m := seedMember(newTestRestoreCR("restore", "backup"))
+	if _, err := kubecli.PersistentVolumeClaims(testNamespace).Get(k8sutil.SeedMemberPVCName(m.Name), metav1.GetOptions{}); err != nil {
+		t.Errorf("seed member PVC wasn't created: %v", err)
+	}
+	for _, name := range []string{"restored", "restored-client"} {
+		if _, err := kubecli.Services(testNamespace).Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("service (%s) wasn't created: %v", name, err)
+		}
+	}
+
+	// The restore waits for the seed member to serve.
+	syncRestore(t, b, "restore", api.RestorePhaseRestoring)
+	updateSeedMemberPod(t, b, func(pod *v1.Pod) {
+		pod.Status.Phase = v1.PodRunning
+		pod.Status.InitContainerStatuses = []v1.ContainerStatus{{
+			Name:  k8sutil.RestoreFetchContainerName,
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0, Message: "backup-name"}},
+		}}
+	})
+	syncRestore(t, b, "restore", api.RestorePhaseRestoring)
+
+	healthy = true
+	er := syncRestore(t, b, "restore", api.RestorePhaseCompleted)
+	if er.Status.RestoredBackupName != "backup-name" {
+		t.Errorf("RestoredBackupName = %q; want %q", er.Status.RestoredBackupName, "backup-name")
+	}
+	if er.Status.CompletionTime == nil {
+		t.Error("CompletionTime isn't set")
+	}
+
+	// The cluster belongs to its owner once the restore completed.
+	if err := kubecli.Pods(testNamespace).Delete(m.Name, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncRestore(t, b, "restore", api.RestorePhaseCompleted)
+	if _, err := kubecli.Pods(testNamespace).Get(m.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("seed member pod of a completed restore was recreated: %v", err)
+	}
+}
+
+func TestRestoreRecreatesSeedMember(t *testing.T) {
+	b := startTestRestore(t)
+	defer b.restoreQueue.ShutDown()
+	pods := b.kubecli.CoreV1().Pods(testNamespace)
+	m := seedMember(newTestRestoreCR("restore", "backup"))
+
+	if err := pods.Delete(m.Name, nil); err != nil {
+		t.Fatal(err)
+	}
+	syncRestore(t, b, "restore", api.RestorePhaseRestoring)
+	pod, err := pods.Get(m.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("seed member pod wasn't recreated: %v", err)
+	}
+	if len(pod.Spec.InitContainers) == 0 {
+		t.Error("recreated seed member pod doesn't restore the backup")
+	}
+}
+
+func TestRestoreFails(t *testing.T) {
+	tests := []struct {
+		name       string
+		update     func(t *testing.T, b *Backup)
+		wantReason string
+	}{{
+		name: "init container failed",
+		update: func(t *testing.T, b *Backup) {
+			updateSeedMemberPod(t, b, func(pod *v1.Pod) {
+				pod.Status.InitContainerStatuses = []v1.ContainerStatus{{
+					Name:         k8sutil.RestoreFetchContainerName,
+					RestartCount: maxInitContainerRestarts,
+					State:        v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1, Message: "no backup"}},
+				}}
+			})
+		},
+		wantReason: "fetch-backup failed: no backup",
+	}, {
+		name: "backup deleted",
+		update: func(t *testing.T, b *Backup) {
+			b.backupCRCli.Delete(context.TODO(), testNamespace, "backup")
+		},
+		wantReason: "backup (backup) was deleted",
+	}}
+	for _, tt := range tests {
+		b := startTestRestore(t)
+		tt.update(t, b)
+		er := syncRestore(t, b, "restore", api.RestorePhaseFailed)
+		if !strings.Contains(er.Status.Reason, tt.wantReason) {
+			t.Errorf("%s: reason %q doesn't contain %q", tt.name, er.Status.Reason, tt.wantReason)
+		}
+		if er.Status.CompletionTime == nil {
+			t.Errorf("%s: CompletionTime isn't set", tt.name)
+		}
+		b.restoreQueue.ShutDown()
+	}
+}
+
+func TestRestoreRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		er         *api.EtcdRestore
+		wantReason string
+	}{{
+		name:       "no backup name",
+		er:         newTestRestoreCR("restore", ""),
+		wantReason: "backupName and clusterName must be set",
+	}, {
+		name:       "backup not found",
+		er:         newTestRestoreCR("restore", "missing"),
+		wantReason: "backup (missing) not found",
+	}, {
+		name: "destination not found",
+		er: func() *api.EtcdRestore {
+			er := newTestRestoreCR("restore", "backup")
+			er.Spec.Destination = "missing"
+			return er
+		}(),
+		wantReason: "destination (missing) not found",
+	}}
+	for _, tt := range tests {
+		b := newTestBackup(newTestBackupCR("backup"))
+		b.restoreCRCli.Create(context.TODO(), tt.er)
+		er := syncRestore(t, b, "restore", api.RestorePhaseFailed)
+		if !strings.Contains(er.Status.Reason, tt.wantReason) {
+			t.Errorf("%s: reason %q doesn't contain %q", tt.name, er.Status.Reason, tt.wantReason)
+		}
+		pods, err := b.kubecli.CoreV1().Pods(testNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pods.Items) != 0 {
+			t.Errorf("%s: rejected restore created pods", tt.name)
+		}
+		b.restoreQueue.ShutDown()
+	}
+}
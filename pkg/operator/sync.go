@@ -1,18 +1,30 @@
 package operator
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/backup/schedule"
 	cluster "github.com/coreos/etcd-backup-operator/pkg/cluster"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
+	eventReasonSyncFailed = "SyncFailed"
+
+	backupQueueName  = "backup"
+	restoreQueueName = "restore"
+
 	// Copy from deployment_controller.go:
-	// maxRetries is the number of times a Vault will be retried before it is dropped out of the queue.
+	// maxRetries is the number of times an EtcdBackup will be retried before it is dropped out of the queue.
 	// With the current rate-limiter in use (5ms*2^(maxRetries-1)) the following numbers represent the times
-	// a Vault is going to be requeued:
+	// an EtcdBackup is going to be requeued:
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
@@ -34,7 +46,18 @@ func (b *Backup) processNextItem() bool {
 	// parallel.
 	defer b.queue.Done(key)
 
+	start := time.Now()
 	err := b.processItem(key.(string))
+	workqueueWorkDurationSeconds.WithLabelValues(backupQueueName).Observe(time.Since(start).Seconds())
+	if _, exists, _ := b.indexer.GetByKey(key.(string)); exists {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		reconcileTotal.WithLabelValues(key.(string), result).Inc()
+	} else {
+		deleteReconcileMetrics(key.(string))
+	}
 	// Handle the error if something went wrong during the execution of the business logic
 	b.handleErr(err, key)
 	return true
@@ -47,27 +70,177 @@ func (b *Backup) processItem(key string) error {
 	}
 	if !exists {
 		logrus.Infof("deleting backup: %s", key)
+		b.setSidecarManaged(key, false)
 		return nil
 	}
 
 	cobj, err := scheme.Scheme.DeepCopy(obj)
+	if err != nil {
+		return err
+	}
 	eb := cobj.(*api.EtcdBackup)
 	logrus.Infof("processing backup: %+v", eb)
 
 	bm, err := cluster.New(b.kubecli, b.serviceAccount, eb)
+	var sched schedule.Schedule
+	if err == nil {
+		sched, err = schedule.New(eb.Spec)
+	}
+	if err != nil {
+		// The spec is invalid: retrying can't help until it is updated.
+		err = &permanentError{err: err}
+	} else {
+		err = bm.Setup()
+	}
 	if err != nil {
-		logrus.Infof("create backup error: (%v) ", err)
+		logrus.Infof("setup backup error: (%v) ", err)
+		if uerr := b.updateConditions(eb, nil, err); uerr != nil {
+			logrus.Warningf("failed to update status of backup (%s): %v", key, uerr)
+		}
 		return err
 	}
+	b.setSidecarManaged(key, true)
+	return b.updateConditions(eb, sched, nil)
+}
 
-	err = bm.Setup()
+// setSidecarManaged records whether the operator manages a sidecar Deployment for the EtcdBackup with key.
+func (b *Backup) setSidecarManaged(key string, managed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if managed {
+		b.sidecars[key] = struct{}{}
+	} else {
+		delete(b.sidecars, key)
+	}
+	managedSidecars.Set(float64(len(b.sidecars)))
+}
+
+// updateConditions reflects the result of setting up the backup sidecar in the conditions of eb.
+// If the sidecar is set up, it also computes the Stale condition from sched,
+// so that backups turn stale even if the sidecar stops reporting.
+// It leaves the other conditions owned by the sidecar untouched.
+func (b *Backup) updateConditions(eb *api.EtcdBackup, sched schedule.Schedule, setupErr error) error {
+	eb, err := b.backupCRCli.Get(context.TODO(), eb.Namespace, eb.Name)
 	if err != nil {
-		logrus.Infof("setup backup error: (%v) ", err)
 		return err
 	}
-	return nil
+	s := &eb.Status
+	if setupErr != nil {
+		s.SetCondition(api.BackupConditionReady, v1.ConditionFalse, api.BackupReasonSidecarFailed, setupErr.Error())
+		s.SetCondition(api.BackupConditionDegraded, v1.ConditionTrue, api.BackupReasonSidecarFailed, setupErr.Error())
+	} else {
+		changed := updateStaleCondition(eb, sched, time.Now())
+		if c := s.GetCondition(api.BackupConditionReady); c == nil || c.Reason == api.BackupReasonSidecarFailed {
+			s.SetCondition(api.BackupConditionReady, v1.ConditionFalse, api.BackupReasonWaitingForFirstBackup, "")
+			changed = true
+		}
+		if c := s.GetCondition(api.BackupConditionDegraded); c == nil || c.Reason == api.BackupReasonSidecarFailed {
+			s.SetCondition(api.BackupConditionDegraded, v1.ConditionFalse, api.BackupReasonSidecarCreated, "")
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+	}
+	_, err = b.backupCRCli.Update(context.TODO(), eb)
+	return err
+}
+
+// updateStaleCondition sets the Stale condition of eb if backups haven't been up to date
+// for StaleAfterIntervals intervals of sched at now, and clears it otherwise.
+// Backups that never succeeded are measured from the creation of eb.
+// It returns true if the condition changed.
+func updateStaleCondition(eb *api.EtcdBackup, sched schedule.Schedule, now time.Time) bool {
+	s := &eb.Status
+	last := eb.CreationTimestamp.Time
+	switch {
+	case s.LastUpToDateTime != nil:
+		last = s.LastUpToDateTime.Time
+	case s.LastSuccessTime != nil:
+		last = s.LastSuccessTime.Time
+	}
+	n := eb.Spec.StaleAfterIntervals
+	if n == 0 {
+		n = api.DefaultStaleAfterIntervals
+	}
+	stale := now.Sub(last) > time.Duration(n)*schedule.IntervalAt(sched, last)
+
+	status, reason := v1.ConditionFalse, api.BackupReasonBackupUpToDate
+	if stale {
+		status, reason = v1.ConditionTrue, api.BackupReasonBackupOutdated
+	}
+	if c := s.GetCondition(api.BackupConditionStale); c != nil && c.Status == status {
+		return false
+	}
+	msg := fmt.Sprintf("backups last up to date at %v", last.UTC().Format(time.RFC3339))
+	s.SetCondition(api.BackupConditionStale, status, reason, msg)
+	return true
+}
+
+// permanentError is an error that retrying a sync can't fix, such as an invalid spec.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
 }
 
 func (b *Backup) handleErr(err error, key interface{}) {
-	// TODO
+	if !handleQueueErr(b.queue, backupQueueName, err, key) {
+		return
+	}
+
+	obj, exists, gerr := b.indexer.GetByKey(key.(string))
+	if gerr != nil || !exists {
+		return
+	}
+	eb := obj.(*api.EtcdBackup)
+	ref := &v1.ObjectReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       api.CRDResourceKind,
+		Namespace:  eb.Namespace,
+		Name:       eb.Name,
+		UID:        eb.UID,
+	}
+	if _, ok := err.(*permanentError); ok {
+		b.recorder.Eventf(ref, v1.EventTypeWarning, eventReasonSyncFailed, "failed to sync backup: %v", err)
+		return
+	}
+	b.recorder.Eventf(ref, v1.EventTypeWarning, eventReasonSyncFailed, "failed to sync backup after %d retries: %v", maxRetries, err)
+}
+
+// handleQueueErr requeues key with backoff if err is not nil, and forgets key otherwise.
+// It returns true if key is dropped out of q because it failed maxRetries times,
+// or at once because err is a permanentError.
+func handleQueueErr(q workqueue.RateLimitingInterface, kind string, err error, key interface{}) bool {
+	if err == nil {
+		// Forget about the #AddRateLimited history of the key on every successful synchronization.
+		// This ensures that future processing of updates for this key is not delayed because of
+		// an outdated error history.
+		q.Forget(key)
+		return false
+	}
+	if _, ok := err.(*permanentError); ok {
+		q.Forget(key)
+		logrus.Errorf("dropping %s (%v) out of the queue: %v", kind, key, err)
+		workqueueDroppedTotal.WithLabelValues(kind).Inc()
+		return true
+	}
+
+	// This controller retries maxRetries times if something goes wrong. After that, it stops trying.
+	if q.NumRequeues(key) < maxRetries {
+		logrus.Errorf("error syncing %s (%v): %v", kind, key, err)
+		workqueueRetriesTotal.WithLabelValues(kind).Inc()
+		// Re-enqueue the key rate limited. Based on the rate limiter on the
+		// queue and the re-enqueue history, the key will be processed later again.
+		q.AddRateLimited(key)
+		return false
+	}
+
+	q.Forget(key)
+	// Report to an external entity that, even after several retries, we could not successfully process this key
+	logrus.Infof("dropping %s (%v) out of the queue: %v", kind, key, err)
+	workqueueDroppedTotal.WithLabelValues(kind).Inc()
+	return true
 }
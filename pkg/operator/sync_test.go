@@ -0,0 +1,94 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestHandleQueueErr(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantDropped bool
+		wantRequeue int
+	}{
+		{name: "success", err: nil, wantDropped: false, wantRequeue: 0},
+		{name: "transient error", err: errors.New("conflict"), wantDropped: false, wantRequeue: 1},
+		{name: "permanent error", err: &permanentError{err: errors.New("invalid spec")}, wantDropped: true, wantRequeue: 0},
+	}
+	for _, tt := range tests {
+		q := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+		if dropped := handleQueueErr(q, backupQueueName, tt.err, "default/backup"); dropped != tt.wantDropped {
+			t.Errorf("%s: dropped = %v; want %v", tt.name, dropped, tt.wantDropped)
+		}
+		if n := q.NumRequeues("default/backup"); n != tt.wantRequeue {
+			t.Errorf("%s: requeued %d times; want %d", tt.name, n, tt.wantRequeue)
+		}
+		q.ShutDown()
+	}
+}
+
+func TestProcessItemInvalidSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    api.EtcdBackupSpec
+		wantErr string
+	}{{
+		name:    "unknown member selection",
+		spec:    api.EtcdBackupSpec{StorageType: api.BackupStorageTypePV, MemberSelection: "random"},
+		wantErr: "unknown member selection policy",
+	}, {
+		name:    "no storage type",
+		spec:    api.EtcdBackupSpec{},
+		wantErr: "storage type not set",
+	}, {
+		name:    "invalid schedule",
+		spec:    api.EtcdBackupSpec{StorageType: api.BackupStorageTypePV, Schedule: "every day"},
+		wantErr: "invalid schedule",
+	}}
+	for _, tt := range tests {
+		eb := &api.EtcdBackup{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "backup"},
+			Spec:       tt.spec,
+		}
+		b := newTestBackup(eb)
+		b.indexer.Add(eb)
+		key := testNamespace + "/backup"
+
+		err := b.processItem(key)
+		if _, ok := err.(*permanentError); !ok || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v; want a permanent error containing %q", tt.name, err, tt.wantErr)
+			continue
+		}
+		b.handleErr(err, key)
+		if n := b.queue.NumRequeues(key); n != 0 {
+			t.Errorf("%s: invalid backup was requeued %d times", tt.name, n)
+		}
+		select {
+		case e := <-b.recorder.(*record.FakeRecorder).Events:
+			if !strings.Contains(e, eventReasonSyncFailed) || !strings.Contains(e, tt.wantErr) {
+				t.Errorf("%s: unexpected event %q", tt.name, e)
+			}
+		default:
+			t.Errorf("%s: no event recorded", tt.name)
+		}
+		got, _ := b.backupCRCli.Get(context.TODO(), testNamespace, "backup")
+		if c := got.Status.GetCondition(api.BackupConditionReady); c == nil || c.Status != v1.ConditionFalse {
+			t.Errorf("%s: Ready condition = %+v; want False", tt.name, c)
+		}
+		if deps, _ := b.kubecli.AppsV1beta1().Deployments(testNamespace).List(metav1.ListOptions{}); len(deps.Items) != 0 {
+			t.Errorf("%s: sidecar was created for an invalid backup", tt.name)
+		}
+		b.queue.ShutDown()
+		b.restoreQueue.ShutDown()
+	}
+}
@@ -9,14 +9,36 @@ const (
 	DefaultSnapshotInterval = 1800 * time.Second
 
 	DefaultBackupPodHTTPPort = 19999
+	DefaultOperatorHTTPPort  = 8080
 
 	OperatorRoot   = "/var/tmp/etcd-operator"
 	BackupMountDir = "/var/etcd-backup"
 
+	EtcdClientTLSDir = "/etc/etcdtls/operator/etcd-tls"
+	EncryptionKeyDir = "/etc/etcd-backup/encryption-keys"
+	S3CADir          = "/etc/etcd-backup/s3-ca"
+	S3CAFile         = "ca.crt"
+
+	GCSCredentialDir  = "/etc/etcd-backup/gcs"
+	GCSCredentialFile = "credentials.json"
+
+	ABSCredentialDir      = "/etc/etcd-backup/abs"
+	ABSStorageAccountFile = "storage-account"
+	ABSStorageKeyFile     = "storage-key"
+	ABSSASTokenFile       = "storage-sas-token"
+
 	PVProvisionerGCEPD  = "kubernetes.io/gce-pd"
 	PVProvisionerAWSEBS = "kubernetes.io/aws-ebs"
 	PVProvisionerNone   = "none"
 
+	DefaultBackupVolumeSizeInMB = 512
+
+	DefaultS3PartSizeInMB      = 16
+	MinS3PartSizeInMB          = 5
+	DefaultS3UploadConcurrency = 4
+
 	EnvOperatorPodName      = "MY_POD_NAME"
 	EnvOperatorPodNamespace = "MY_POD_NAMESPACE"
+	// EnvBackupAPIToken holds the bearer token clients of the HTTP API of the backup sidecar must present.
+	EnvBackupAPIToken = "BACKUP_API_TOKEN"
 )
@@ -47,6 +47,24 @@ func NewTLSConfig(certData, keyData, caData []byte) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
+// NewTLSConfigFromDir creates the client TLS config from the files
+// CliCertFile, CliKeyFile and CliCAFile in dir.
+func NewTLSConfigFromDir(dir string) (*tls.Config, error) {
+	certData, err := ioutil.ReadFile(filepath.Join(dir, CliCertFile))
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := ioutil.ReadFile(filepath.Join(dir, CliKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	caData, err := ioutil.ReadFile(filepath.Join(dir, CliCAFile))
+	if err != nil {
+		return nil, err
+	}
+	return NewTLSConfig(certData, keyData, caData)
+}
+
 func writeFile(dir, file string, data []byte) (string, error) {
 	p := filepath.Join(dir, file)
 	return p, ioutil.WriteFile(p, data, 0600)
@@ -1,6 +1,9 @@
 package k8sutil
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 
@@ -9,6 +12,7 @@ import (
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 var (
@@ -16,12 +20,15 @@ var (
 	BackupSpec  = "BACKUP_SPEC"
 )
 
-func NewBackupPodTemplate(account string, bs api.EtcdBackupSpec) v1.PodTemplateSpec {
+func mustMarshalBackupSpec(bs api.EtcdBackupSpec) string {
 	b, err := json.Marshal(bs)
 	if err != nil {
 		panic("unexpected json error " + err.Error())
 	}
+	return string(b)
+}
 
+func NewBackupPodTemplate(account, backupName string, bs api.EtcdBackupSpec) v1.PodTemplateSpec {
 	ps := v1.PodSpec{
 		ServiceAccountName: account,
 		Containers: []v1.Container{
@@ -31,22 +38,29 @@ func NewBackupPodTemplate(account string, bs api.EtcdBackupSpec) v1.PodTemplateS
 				Command: []string{
 					"/usr/local/bin/etcd-backup",
 					"--etcd-cluster=" + bs.ClusterName,
+					"--backup-name=" + backupName,
 				},
+				Ports: []v1.ContainerPort{{
+					Name:          "http",
+					ContainerPort: constants.DefaultBackupPodHTTPPort,
+					Protocol:      v1.ProtocolTCP,
+				}},
 				Env: []v1.EnvVar{{
 					Name:      constants.EnvOperatorPodNamespace,
 					ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
 				}, {
 					Name:  BackupSpec,
-					Value: string(b),
+					Value: mustMarshalBackupSpec(bs),
 				}},
 			},
 		},
 	}
+	AttachBackupAPITokenToContainer(&ps.Containers[0], backupName)
 
 	pl := v1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   bs.ClusterName,
-			Labels: BackupSidecarLabels(bs.ClusterName),
+			Labels: BackupSidecarLabels(bs.ClusterName, backupName),
 		},
 		Spec: ps,
 	}
@@ -57,12 +71,48 @@ func NewBackupPodTemplate(account string, bs api.EtcdBackupSpec) v1.PodTemplateS
 const (
 	awsCredentialDir          = "/root/.aws/"
 	awsSecretVolName          = "secret-aws"
+	etcdClientTLSVolName      = "etcd-client-tls"
+	encryptionKeyVolName      = "encryption-keys"
+	s3CAVolName               = "s3-ca"
+	gcpSecretVolName          = "secret-gcp"
+	absSecretVolName          = "secret-abs"
 	AWSS3Bucket               = "AWS_S3_BUCKET"
 	BackupPodSelectorAppField = "etcd_backup_tool"
+	backupNameLabelKey        = "etcd_backup"
+	// BackupAPITokenKey is the key of the bearer token in the Secret returned by NewBackupAPITokenSecret.
+	BackupAPITokenKey = "token"
+
+	specHashAnnotation = "etcd.database.coreos.com/spec-hash"
 )
 
-func AttachS3ToPodSpec(ps *v1.PodSpec, ss *api.S3Source) {
-	ps.Containers[0].VolumeMounts = append(ps.Containers[0].VolumeMounts, v1.VolumeMount{
+// AttachStorageToPodSpec attaches the volumes and environment the storage destinations
+// of the EtcdBackup named backupName need to container c of ps.
+func AttachStorageToPodSpec(ps *v1.PodSpec, c *v1.Container, backupName string, bs api.EtcdBackupSpec) {
+	for _, d := range bs.GetDestinations() {
+		AttachDestinationToPodSpec(ps, c, backupName, d)
+	}
+	if bs.Encryption != nil {
+		AttachEncryptionKeysToPodSpec(ps, c, bs.Encryption.KeySecret)
+	}
+}
+
+// AttachDestinationToPodSpec attaches the volumes and environment destination d
+// of the EtcdBackup named backupName needs to container c of ps.
+func AttachDestinationToPodSpec(ps *v1.PodSpec, c *v1.Container, backupName string, d api.BackupDestination) {
+	switch d.StorageType {
+	case api.BackupStorageTypeS3:
+		AttachS3ToPodSpec(ps, c, d.S3)
+	case api.BackupStorageTypePV:
+		AttachPVToPodSpec(ps, c, BackupPVCName(backupName))
+	case api.BackupStorageTypeGCS:
+		AttachGCSToPodSpec(ps, c, d.GCS)
+	case api.BackupStorageTypeABS:
+		AttachABSToPodSpec(ps, c, d.ABS)
+	}
+}
+
+func AttachS3ToPodSpec(ps *v1.PodSpec, c *v1.Container, ss *api.S3Source) {
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
 		Name:      awsSecretVolName,
 		MountPath: awsCredentialDir,
 	})
@@ -74,23 +124,204 @@ func AttachS3ToPodSpec(ps *v1.PodSpec, ss *api.S3Source) {
 			},
 		},
 	})
-	ps.Containers[0].Env = append(ps.Containers[0].Env, v1.EnvVar{
+	c.Env = append(c.Env, v1.EnvVar{
 		Name:  AWSS3Bucket,
 		Value: ss.S3Bucket,
 	})
+	if len(ss.CASecret) != 0 {
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      s3CAVolName,
+			MountPath: constants.S3CADir,
+			ReadOnly:  true,
+		})
+		ps.Volumes = append(ps.Volumes, v1.Volume{
+			Name: s3CAVolName,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: ss.CASecret,
+				},
+			},
+		})
+	}
+}
+
+// AttachGCSToPodSpec mounts the GCP service account key secret into container c of ps.
+func AttachGCSToPodSpec(ps *v1.PodSpec, c *v1.Container, gs *api.GCSSource) {
+	if len(gs.GCPSecret) == 0 {
+		return
+	}
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      gcpSecretVolName,
+		MountPath: constants.GCSCredentialDir,
+		ReadOnly:  true,
+	})
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: gcpSecretVolName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: gs.GCPSecret,
+			},
+		},
+	})
+}
+
+// AttachABSToPodSpec mounts the Azure storage account credentials secret into container c of ps.
+func AttachABSToPodSpec(ps *v1.PodSpec, c *v1.Container, as *api.ABSSource) {
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      absSecretVolName,
+		MountPath: constants.ABSCredentialDir,
+		ReadOnly:  true,
+	})
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: absSecretVolName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: as.ABSSecret,
+			},
+		},
+	})
+}
+
+// AttachClientTLSToPodSpec mounts the etcd client TLS secret into container c of ps.
+func AttachClientTLSToPodSpec(ps *v1.PodSpec, c *v1.Container, secret string) {
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      etcdClientTLSVolName,
+		MountPath: constants.EtcdClientTLSDir,
+		ReadOnly:  true,
+	})
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: etcdClientTLSVolName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: secret,
+			},
+		},
+	})
+}
+
+// AttachEncryptionKeysToPodSpec mounts the backup encryption keys secret into container c of ps.
+func AttachEncryptionKeysToPodSpec(ps *v1.PodSpec, c *v1.Container, secret string) {
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      encryptionKeyVolName,
+		MountPath: constants.EncryptionKeyDir,
+		ReadOnly:  true,
+	})
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: encryptionKeyVolName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: secret,
+			},
+		},
+	})
 }
 
 func BackupSidecarName(name string) string {
 	return fmt.Sprintf("%s-backup-sidecar", name)
 }
 
-func BackupSidecarLabels(clusterName string) map[string]string {
+// BackupSidecarURL returns the URL of the HTTP API of the sidecar of the EtcdBackup named name,
+// as seen from pods in its namespace.
+func BackupSidecarURL(name string) string {
+	return fmt.Sprintf("http://%s:%d", BackupSidecarName(name), constants.DefaultBackupPodHTTPPort)
+}
+
+// BackupAPITokenSecretName returns the name of the Secret holding the bearer token
+// of the HTTP API of the sidecar of the EtcdBackup named name.
+func BackupAPITokenSecretName(name string) string {
+	return fmt.Sprintf("%s-backup-api-token", name)
+}
+
+// NewBackupAPITokenSecret returns the Secret holding a new random bearer token
+// of the HTTP API of the sidecar of the EtcdBackup named backupName.
+func NewBackupAPITokenSecret(backupName, clusterName string, owner metav1.OwnerReference) (*v1.Secret, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate API token: %v", err)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   BackupAPITokenSecretName(backupName),
+			Labels: LabelsForCluster(clusterName),
+		},
+		Data: map[string][]byte{
+			BackupAPITokenKey: []byte(hex.EncodeToString(b)),
+		},
+	}
+	AddOwnerRefToObject(secret.GetObjectMeta(), owner)
+	return secret, nil
+}
+
+// AttachBackupAPITokenToContainer passes the bearer token of the HTTP API of the sidecar
+// of the EtcdBackup named backupName to container c.
+func AttachBackupAPITokenToContainer(c *v1.Container, backupName string) {
+	c.Env = append(c.Env, v1.EnvVar{
+		Name: constants.EnvBackupAPIToken,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: BackupAPITokenSecretName(backupName)},
+				Key:                  BackupAPITokenKey,
+			},
+		},
+	})
+}
+
+// NewBackupServiceManifest returns the service that exposes the HTTP API of the sidecar of the EtcdBackup named backupName.
+func NewBackupServiceManifest(name, clusterName, backupName string, owner metav1.OwnerReference) *v1.Service {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: LabelsForCluster(clusterName),
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:       "http",
+				Port:       constants.DefaultBackupPodHTTPPort,
+				TargetPort: intstr.FromInt(constants.DefaultBackupPodHTTPPort),
+				Protocol:   v1.ProtocolTCP,
+			}},
+			Selector: BackupSidecarLabels(clusterName, backupName),
+		},
+	}
+	AddOwnerRefToObject(svc.GetObjectMeta(), owner)
+	return svc
+}
+
+// BackupSidecarLabels returns the labels of the sidecar pods of the EtcdBackup named backupName.
+// Several EtcdBackups can back up the same cluster, so the labels include the name of the EtcdBackup.
+func BackupSidecarLabels(clusterName, backupName string) map[string]string {
 	return map[string]string{
-		"app":          BackupPodSelectorAppField,
-		"etcd_cluster": clusterName,
+		"app":              BackupPodSelectorAppField,
+		"etcd_cluster":     clusterName,
+		backupNameLabelKey: backupName,
 	}
 }
 
+// HashDeploymentSpec returns a hash of the spec of d.
+func HashDeploymentSpec(d *appsv1beta1.Deployment) string {
+	b, err := json.Marshal(d.Spec)
+	if err != nil {
+		panic("unexpected json error " + err.Error())
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// SetSpecHash records the hash of the desired spec of o on it.
+func SetSpecHash(o metav1.Object, hash string) {
+	anno := o.GetAnnotations()
+	if anno == nil {
+		anno = map[string]string{}
+	}
+	anno[specHashAnnotation] = hash
+	o.SetAnnotations(anno)
+}
+
+// GetSpecHash returns the hash of the desired spec recorded on o.
+func GetSpecHash(o metav1.Object) string {
+	return o.GetAnnotations()[specHashAnnotation]
+}
+
 func NewBackupDeploymentManifest(name string, dplSel map[string]string, pl v1.PodTemplateSpec, owner metav1.OwnerReference) *appsv1beta1.Deployment {
 	d := &appsv1beta1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
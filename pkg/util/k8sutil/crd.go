@@ -15,18 +15,26 @@ import (
 )
 
 func CreateBackupCRD(clientset apiextensionsclient.Interface) error {
+	return createCRD(clientset, api.CRDName, api.CRDResourceKind, api.CRDResourcePlural, api.CRDResourceShortName)
+}
+
+func CreateRestoreCRD(clientset apiextensionsclient.Interface) error {
+	return createCRD(clientset, api.RestoreCRDName, api.RestoreCRDResourceKind, api.RestoreCRDResourcePlural, api.RestoreCRDResourceShortName)
+}
+
+func createCRD(clientset apiextensionsclient.Interface, crdName, kind, plural string, shortNames []string) error {
 	crd := &apiextensionsv1beta1.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: api.CRDName,
+			Name: crdName,
 		},
 		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
 			Group:   api.SchemeGroupVersion.Group,
 			Version: api.SchemeGroupVersion.Version,
 			Scope:   apiextensionsv1beta1.NamespaceScoped,
 			Names: apiextensionsv1beta1.CustomResourceDefinitionNames{
-				Plural:     api.CRDResourcePlural,
-				Kind:       api.CRDResourceKind,
-				ShortNames: api.CRDResourceShortName,
+				Plural:     plural,
+				Kind:       kind,
+				ShortNames: shortNames,
 			},
 		},
 	}
@@ -37,7 +45,7 @@ func CreateBackupCRD(clientset apiextensionsclient.Interface) error {
 
 	// wait for CR creation
 	return wait.Poll(500*time.Millisecond, 60*time.Second, func() (bool, error) {
-		crd, err := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(api.CRDName, metav1.GetOptions{})
+		crd, err := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(crdName, metav1.GetOptions{})
 		if err != nil {
 			return false, err
 		}
@@ -45,6 +45,18 @@ func AsOwner(eb *api.EtcdBackup) metav1.OwnerReference {
 	}
 }
 
+// AsRestoreOwner returns an owner reference to er.
+func AsRestoreOwner(er *api.EtcdRestore) metav1.OwnerReference {
+	trueVar := true
+	return metav1.OwnerReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       api.RestoreCRDResourceKind,
+		Name:       er.Name,
+		UID:        er.UID,
+		Controller: &trueVar,
+	}
+}
+
 func LabelsForCluster(clusterName string) map[string]string {
 	return map[string]string{
 		"etcd_cluster": clusterName,
@@ -0,0 +1,94 @@
+package k8sutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	storageClassPrefix = "etcd-backup"
+	backupPVVolName    = "etcd-backup-storage"
+)
+
+func BackupPVCName(name string) string {
+	return fmt.Sprintf("%s-backup-pvc", name)
+}
+
+// StorageClassName returns the name of the StorageClass the operator creates for pvProvisioner.
+func StorageClassName(pvProvisioner string) string {
+	// We need to get rid of prefix because naming doesn't support "/".
+	sc := strings.TrimPrefix(pvProvisioner, "kubernetes.io/")
+	return storageClassPrefix + "-" + sc
+}
+
+// IsValidPVProvisioner tells whether pvProvisioner is supported for backup volumes.
+func IsValidPVProvisioner(pvProvisioner string) bool {
+	switch pvProvisioner {
+	case constants.PVProvisionerGCEPD, constants.PVProvisionerAWSEBS, constants.PVProvisionerNone:
+		return true
+	}
+	return false
+}
+
+// CreateStorageClass creates the StorageClass for pvProvisioner.
+// Nothing is created for PVProvisionerNone.
+func CreateStorageClass(kubecli kubernetes.Interface, pvProvisioner string) error {
+	if pvProvisioner == constants.PVProvisionerNone {
+		return nil
+	}
+	class := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: StorageClassName(pvProvisioner),
+		},
+		Provisioner: pvProvisioner,
+	}
+	_, err := kubecli.StorageV1().StorageClasses().Create(class)
+	return err
+}
+
+func NewBackupPVC(name, clusterName, pvProvisioner string, volumeSizeInMB int) *v1.PersistentVolumeClaim {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: LabelsForCluster(clusterName),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse(fmt.Sprintf("%dMi", volumeSizeInMB)),
+				},
+			},
+		},
+	}
+	if pvProvisioner != constants.PVProvisionerNone {
+		sc := StorageClassName(pvProvisioner)
+		pvc.Spec.StorageClassName = &sc
+	}
+	return pvc
+}
+
+func AttachPVToPodSpec(ps *v1.PodSpec, c *v1.Container, pvcName string) {
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      backupPVVolName,
+		MountPath: constants.BackupMountDir,
+	})
+	ps.Volumes = append(ps.Volumes, v1.Volume{
+		Name: backupPVVolName,
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: pvcName,
+			},
+		},
+	})
+}
@@ -0,0 +1,309 @@
+package k8sutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	api "github.com/coreos/etcd-backup-operator/pkg/apis/backup/v1alpha1"
+	"github.com/coreos/etcd-backup-operator/pkg/util/constants"
+	"github.com/coreos/etcd-backup-operator/pkg/util/etcdutil"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	EtcdImage = "quay.io/coreos/etcd"
+
+	EtcdClientPort = 2379
+	EtcdPeerPort   = 2380
+
+	TerminationMessagePath = "/dev/termination-log"
+
+	// RestoreFetchContainerName is the name of the init container that downloads the backup.
+	RestoreFetchContainerName = "fetch-backup"
+	// RestoreDataDirContainerName is the name of the init container that restores the etcd data dir.
+	RestoreDataDirContainerName = "restore-datadir"
+	// RestoreLabelKey is the label that holds the name of the EtcdRestore on the objects created for it.
+	RestoreLabelKey = "etcd_restore"
+
+	etcdVolumeName      = "etcd-data"
+	etcdVolumeMountDir  = "/var/etcd"
+	etcdDataDir         = etcdVolumeMountDir + "/data"
+	restoreBackupFile   = etcdVolumeMountDir + "/backup.db"
+	tolerateUnreadyAnno = "service.alpha.kubernetes.io/tolerate-unready-endpoints"
+)
+
+// RestoreLabels returns the labels of the seed member pod and PVC created for the EtcdRestore named restoreName.
+func RestoreLabels(clusterName, restoreName string) map[string]string {
+	l := LabelsForCluster(clusterName)
+	l[RestoreLabelKey] = restoreName
+	return l
+}
+
+// IsRestorePod tells whether pod is the seed member pod created for the EtcdRestore named restoreName.
+func IsRestorePod(pod *v1.Pod, restoreName string) bool {
+	return pod.Labels[RestoreLabelKey] == restoreName
+}
+
+// NewPeerService returns the headless service that gives etcd members of the cluster their DNS names.
+func NewPeerService(clusterName string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterName,
+			Labels: LabelsForCluster(clusterName),
+			Annotations: map[string]string{
+				// Members need to be resolvable before they are ready to bootstrap the cluster.
+				tolerateUnreadyAnno: "true",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Ports: []v1.ServicePort{{
+				Name:       "client",
+				Port:       EtcdClientPort,
+				TargetPort: intstr.FromInt(EtcdClientPort),
+				Protocol:   v1.ProtocolTCP,
+			}, {
+				Name:       "peer",
+				Port:       EtcdPeerPort,
+				TargetPort: intstr.FromInt(EtcdPeerPort),
+				Protocol:   v1.ProtocolTCP,
+			}},
+			Selector: LabelsForCluster(clusterName),
+		},
+	}
+}
+
+// NewClientService returns the service clients of the cluster connect to.
+func NewClientService(clusterName string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterName + "-client",
+			Labels: LabelsForCluster(clusterName),
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:       "client",
+				Port:       EtcdClientPort,
+				TargetPort: intstr.FromInt(EtcdClientPort),
+				Protocol:   v1.ProtocolTCP,
+			}},
+			Selector: LabelsForCluster(clusterName),
+		},
+	}
+}
+
+// SeedMemberPVCName returns the name of the PVC the seed member named memberName keeps its data on.
+func SeedMemberPVCName(memberName string) string {
+	return memberName + "-data"
+}
+
+// NewSeedMemberPVC returns the PVC the seed member m of the cluster restored by er keeps its data on.
+func NewSeedMemberPVC(m *etcdutil.Member, er *api.EtcdRestore) *v1.PersistentVolumeClaim {
+	rs := er.Spec
+	size := rs.VolumeSizeInMB
+	if size == 0 {
+		size = api.DefaultSeedVolumeSizeInMB
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   SeedMemberPVCName(m.Name),
+			Labels: RestoreLabels(rs.ClusterName, er.Name),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse(fmt.Sprintf("%dMi", size)),
+				},
+			},
+		},
+	}
+	if len(rs.StorageClassName) != 0 {
+		sc := rs.StorageClassName
+		pvc.Spec.StorageClassName = &sc
+	}
+	AddOwnerRefToObject(pvc.GetObjectMeta(), AsRestoreOwner(er))
+	return pvc
+}
+
+// NewSeedMemberPod returns the pod of the first member m of the cluster restored by er.
+// Its init containers download the backup from the storage of eb and restore the etcd data dir from it,
+// unless the data dir on the PVC of the member was restored already.
+func NewSeedMemberPod(account string, m *etcdutil.Member, er *api.EtcdRestore, eb *api.EtcdBackup) *v1.Pod {
+	rs := er.Spec
+	version := rs.EtcdVersion
+	if len(version) == 0 {
+		version = api.DefaultEtcdVersion
+	}
+	image := fmt.Sprintf("%s:v%s", EtcdImage, strings.TrimPrefix(version, "v"))
+	initialCluster := fmt.Sprintf("%s=%s", m.Name, m.PeerURL())
+	token := string(er.UID)
+	dataMount := v1.VolumeMount{Name: etcdVolumeName, MountPath: etcdVolumeMountDir}
+
+	etcd := v1.Container{
+		Name:  "etcd",
+		Image: image,
+		Command: []string{
+			"/usr/local/bin/etcd",
+			"--data-dir=" + etcdDataDir,
+			"--name=" + m.Name,
+			"--initial-advertise-peer-urls=" + m.PeerURL(),
+			"--listen-peer-urls=" + m.ListenPeerURL(),
+			"--listen-client-urls=" + m.ListenClientURL(),
+			"--advertise-client-urls=" + m.ClientURL(),
+			"--initial-cluster=" + initialCluster,
+			"--initial-cluster-state=new",
+			"--initial-cluster-token=" + token,
+		},
+		Ports: []v1.ContainerPort{{
+			Name:          "client",
+			ContainerPort: EtcdClientPort,
+			Protocol:      v1.ProtocolTCP,
+		}, {
+			Name:          "peer",
+			ContainerPort: EtcdPeerPort,
+			Protocol:      v1.ProtocolTCP,
+		}},
+		VolumeMounts: []v1.VolumeMount{dataMount},
+	}
+
+	ps := v1.PodSpec{
+		ServiceAccountName: account,
+		Containers:         []v1.Container{etcd},
+		Volumes: []v1.Volume{{
+			Name: etcdVolumeName,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: SeedMemberPVCName(m.Name),
+				},
+			},
+		}},
+		Hostname:       m.Name,
+		Subdomain:      rs.ClusterName,
+		InitContainers: newRestoreInitContainers(m, er, eb, image, initialCluster, token),
+	}
+	attachFetchStorageToPodSpec(&ps, &ps.InitContainers[0], rs, eb)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   m.Name,
+			Labels: RestoreLabels(rs.ClusterName, er.Name),
+		},
+		Spec: ps,
+	}
+	AddOwnerRefToObject(pod.GetObjectMeta(), AsRestoreOwner(er))
+	return pod
+}
+
+// newRestoreInitContainers returns the init containers that download the backup of eb
+// and restore the etcd data dir of the seed member m from it.
+// Both are skipped once the data dir exists, so that restarts of the pod keep the data written since.
+func newRestoreInitContainers(m *etcdutil.Member, er *api.EtcdRestore, eb *api.EtcdBackup, image, initialCluster, token string) []v1.Container {
+	rs := er.Spec
+	dataMount := v1.VolumeMount{Name: etcdVolumeName, MountPath: etcdVolumeMountDir}
+
+	fetchCmd := []string{
+		"/usr/local/bin/etcd-restore",
+		"--etcd-cluster=" + eb.Spec.ClusterName,
+		"--output=" + restoreBackupFile,
+		"--skip-if-exists=" + etcdDataDir,
+	}
+	if len(rs.Key) != 0 {
+		fetchCmd = append(fetchCmd, "--key="+rs.Key)
+	}
+	if rs.Revision != 0 {
+		fetchCmd = append(fetchCmd, "--revision="+strconv.FormatInt(rs.Revision, 10))
+	}
+	if len(rs.Destination) != 0 {
+		fetchCmd = append(fetchCmd, "--destination="+rs.Destination)
+	}
+	// The PVC of a PV destination is ReadWriteOnce and mounted by the sidecar,
+	// so the backup is downloaded through the HTTP API of the sidecar instead.
+	d, ok := eb.Spec.GetDestination(rs.Destination)
+	viaSidecar := ok && d.StorageType == api.BackupStorageTypePV
+	if viaSidecar {
+		fetchCmd = append(fetchCmd, "--sidecar-url="+BackupSidecarURL(eb.Name))
+	}
+	fetch := v1.Container{
+		Name:    RestoreFetchContainerName,
+		Image:   BackupImage,
+		Command: fetchCmd,
+		Env: []v1.EnvVar{{
+			Name:      constants.EnvOperatorPodNamespace,
+			ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+		}, {
+			Name:  BackupSpec,
+			Value: mustMarshalBackupSpec(eb.Spec),
+		}},
+		VolumeMounts:             []v1.VolumeMount{dataMount},
+		TerminationMessagePath:   TerminationMessagePath,
+		TerminationMessagePolicy: v1.TerminationMessageFallbackToLogsOnError,
+	}
+	if viaSidecar {
+		AttachBackupAPITokenToContainer(&fetch, eb.Name)
+	}
+
+	restoreCmd := fmt.Sprintf("[ -d %[1]s ] || ETCDCTL_API=3 etcdctl snapshot restore %[2]s"+
+		" --name %[3]s --initial-cluster %[4]s --initial-cluster-token %[5]s"+
+		" --initial-advertise-peer-urls %[6]s --data-dir %[1]s",
+		etcdDataDir, restoreBackupFile, m.Name, initialCluster, token, m.PeerURL())
+	restore := v1.Container{
+		Name:                     RestoreDataDirContainerName,
+		Image:                    image,
+		Command:                  []string{"/bin/sh", "-ec", restoreCmd},
+		VolumeMounts:             []v1.VolumeMount{dataMount},
+		TerminationMessagePolicy: v1.TerminationMessageFallbackToLogsOnError,
+	}
+	return []v1.Container{fetch, restore}
+}
+
+// attachFetchStorageToPodSpec attaches the storage the fetch init container c
+// needs to read the backup restored by rs from the destination of eb.
+func attachFetchStorageToPodSpec(ps *v1.PodSpec, c *v1.Container, rs api.EtcdRestoreSpec, eb *api.EtcdBackup) {
+	d, ok := eb.Spec.GetDestination(rs.Destination)
+	if !ok || d.StorageType == api.BackupStorageTypePV {
+		// The sidecar serves backups of PV destinations decrypted.
+		return
+	}
+	AttachDestinationToPodSpec(ps, c, eb.Name, d)
+	if eb.Spec.Encryption != nil {
+		AttachEncryptionKeysToPodSpec(ps, c, eb.Spec.Encryption.KeySecret)
+	}
+}
+
+// RestoredBackupName returns the name of the backup the fetch init container of pod downloaded.
+func RestoredBackupName(pod *v1.Pod) string {
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name == RestoreFetchContainerName && cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0 {
+			return cs.State.Terminated.Message
+		}
+	}
+	return ""
+}
+
+// FailedInitContainer returns the state of the first init container of pod that failed
+// after being restarted maxRestarts times, or nil if none did.
+// Init containers are restarted on failure, so transient failures don't fail the pod.
+func FailedInitContainer(pod *v1.Pod, maxRestarts int32) (string, *v1.ContainerStateTerminated) {
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.RestartCount < maxRestarts {
+			continue
+		}
+		t := cs.State.Terminated
+		if t == nil {
+			// The container waits to be restarted after its last failure.
+			t = cs.LastTerminationState.Terminated
+		}
+		if t != nil && t.ExitCode != 0 {
+			return cs.Name, t
+		}
+	}
+	return "", nil
+}